@@ -6,25 +6,47 @@ import (
 	"context"
 	"fmt"
 	"io/ioutil"
+	"os"
+	"sort"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/ashutosh-the-beast/newknox/metrics"
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
 )
 
 const (
-	defaultK8sConfigMap = "kmux"
-	k8sConfigMapKey     = "config.yaml"
+	defaultPulsarMaxPendingMessages = 1
+	defaultPulsarBatchingMaxSize    = 5242880 // 5MB
+)
+
+const (
+	defaultKnoxGatewayReconnectInitialInterval = time.Second
+	defaultKnoxGatewayReconnectMaxInterval     = 30 * time.Second
+	defaultKnoxGatewayReconnectMultiplier      = 2.0
+	defaultKnoxGatewayReconnectJitter          = 0.2
+	defaultKnoxGatewayReconnectBufferSize      = 1000
+	defaultKnoxGatewayReconnectFlushTimeout    = 30 * time.Second
 )
 
 const (
 	// PulsarDriver specifies the source/sink instance uses Apache Pulsar
 	PulsarDriver = "pulsar"
 
+	// KafkaDriver specifies the source/sink instance uses Apache Kafka
+	KafkaDriver = "kafka"
+
 	// KnoxGatewayDriver specifies the sink instance uses Accuknox gRPC gateway
 	KnoxGatewayDriver = "knox-gateway"
 
@@ -51,18 +73,90 @@ type InterfaceConfig struct {
 	DatabaseDriver string
 }
 
+// MetricsConfig contains the settings of prometheus metrics exported by kmux
+type MetricsConfig struct {
+	Enable    bool
+	Namespace string
+}
+
+// TracingConfig contains the settings of OpenTelemetry tracing of kmux sinks and sources
+type TracingConfig struct {
+	Enable bool
+}
+
+// LogConfig contains the settings of kmux logs
+type LogConfig struct {
+	// Redact are the patterns of the configuration keys whose values are not logged,
+	// in addition to the built-in patterns such as `*password*`
+	Redact []string
+}
+
 // AppConfig contains source and sink configuration
 type AppConfig struct {
-	Sink   InterfaceConfig
-	Source InterfaceConfig
-	Vault  string
+	Sink    InterfaceConfig
+	Source  InterfaceConfig
+	Vault   string
+	Metrics MetricsConfig
+	Tracing TracingConfig
+	Log     LogConfig
+}
+
+// PulsarProducerConfig contains Apache Pulsar producer batching and queueing configuration
+type PulsarProducerConfig struct {
+	SendTimeout             time.Duration
+	MaxPendingMessages      int
+	DisableBlockIfQueueFull bool
+	DisableBatching         bool
+	BatchingMaxPublishDelay time.Duration
+	BatchingMaxMessages     uint
+	BatchingMaxSize         uint
+}
+
+// PulsarSchemaConfig contains the schema of an Apache Pulsar topic
+type PulsarSchemaConfig struct {
+	Type           string
+	Definition     string
+	DefinitionFile string
+}
+
+// PulsarTopicConfig contains Apache Pulsar topic specific configuration
+type PulsarTopicConfig struct {
+	Schema *PulsarSchemaConfig
 }
 
 // PulsarConfig contains Apache Pulsar related configuration
 type PulsarConfig struct {
-	TopicPrefix  string
-	Options      pulsar.ClientOptions
-	Subscription string
+	TopicPrefix      string
+	Options          pulsar.ClientOptions
+	Producer         PulsarProducerConfig
+	Subscription     string
+	SubscriptionType pulsar.SubscriptionType
+	Topics           map[string]PulsarTopicConfig
+}
+
+// KafkaTLSConfig contains TLS/mTLS settings used to connect with Kafka brokers
+type KafkaTLSConfig struct {
+	CACert string
+	Cert   string
+	Key    string
+}
+
+// KafkaSASLConfig contains SASL settings used to authenticate with Kafka brokers
+type KafkaSASLConfig struct {
+	Mechanism string
+	Username  string
+	Password  string
+}
+
+// KafkaConfig contains Apache Kafka related configuration
+type KafkaConfig struct {
+	Brokers     []string
+	TopicPrefix string
+	Version     string
+	Acks        string
+	Compression string
+	TLS         *KafkaTLSConfig
+	SASL        *KafkaSASLConfig
 }
 
 // DatabaseVaultKey contains key for vault secrets
@@ -87,14 +181,65 @@ type DatabaseConfig struct {
 	ConnParams []string
 }
 
-// HashiVaultConfig contains Server address to connect with Vault
+// HashiVaultAuthConfig contains the credentials used to authenticate with Vault
+type HashiVaultAuthConfig struct {
+	Method   string
+	Mount    string
+	Token    string
+	Role     string
+	JWTPath  string
+	RoleID   string
+	SecretID string
+}
+
+// HashiVaultConfig contains Server address and credentials to connect with Vault
 type HashiVaultConfig struct {
-	Server string
+	Server    string
+	Namespace string
+	KVVersion int
+	KVMount   string
+	Auth      HashiVaultAuthConfig
+}
+
+// KnoxGatewayReconnectConfig contains the backoff settings used to re-establish
+// a broken stream with AccuKnox GRPC Gateway
+type KnoxGatewayReconnectConfig struct {
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+	Multiplier      float64
+	Jitter          float64
+	MaxAttempts     int
+	BufferSize      int
+	FlushTimeout    time.Duration
+}
+
+// KnoxGatewayTLSConfig contains TLS/mTLS settings used to connect with AccuKnox GRPC Gateway
+type KnoxGatewayTLSConfig struct {
+	CACert     string
+	Cert       string
+	Key        string
+	ServerName string
 }
 
 // KnoxGatewayConfig contains AccuKnox GRPC Gateway related configuration
 type KnoxGatewayConfig struct {
-	Server string
+	Server    string
+	TLS       *KnoxGatewayTLSConfig
+	Token     string
+	Reconnect KnoxGatewayReconnectConfig
+}
+
+// CodecConfig contains the codec used to encode the messages of a topic
+type CodecConfig struct {
+	Codec      string
+	Schema     string
+	SchemaFile string
+}
+
+// CodecsConfig contains the default codec and the codecs configured per topic
+type CodecsConfig struct {
+	Default string
+	Topics  map[string]CodecConfig
 }
 
 // App holds the information about source and sink drivers used in the application
@@ -103,6 +248,9 @@ var App AppConfig
 // Pulsar configurations
 var Pulsar PulsarConfig
 
+// Kafka configurations
+var Kafka KafkaConfig
+
 // KnoxGateway configurations
 var KnoxGateway KnoxGatewayConfig
 
@@ -112,37 +260,151 @@ var Database DatabaseConfig
 // Vault - HashiVaultConfig configurations
 var Vault HashiVaultConfig
 
+// Codecs holds the payload codecs configurations
+var Codecs CodecsConfig
+
 // Viper instance to parse kmux configuration
 var Viper *viper.Viper
 
-// Init initializes kmux configuration
+// current is the active configuration, swapped whenever the configuration is reloaded.
+// The package level variables are set by Init() only, as they can not be updated
+// safely while they are read by other goroutines.
+var current atomic.Pointer[Config]
+
+// Config holds a parsed kmux configuration. The package level variables
+// hold the configuration loaded by Init(), and Current() returns the
+// configuration reloaded since then.
+type Config struct {
+	App         AppConfig
+	Pulsar      PulsarConfig
+	Kafka       KafkaConfig
+	KnoxGateway KnoxGatewayConfig
+	Database    DatabaseConfig
+	Vault       HashiVaultConfig
+	Codecs      CodecsConfig
+
+	// Viper instance used to parse the configuration
+	Viper *viper.Viper
+
+	// Metrics records the metrics of the sinks and sources created with the configuration.
+	// The metrics registered by metrics.Register() are used when nil.
+	Metrics *metrics.Metrics
+
+	// TracerProvider and Propagator trace the sinks and sources created with the configuration.
+	// The tracing enabled by stream.EnableTracing() is used when TracerProvider is nil.
+	TracerProvider trace.TracerProvider
+	Propagator     propagation.TextMapPropagator
+
+	// flags are the command line flags overriding the configuration keys
+	flags *pflag.FlagSet
+}
+
+// Init initializes kmux configuration. The configuration is installed once it is
+// loaded and, with Options.WatchConfig, watched, hence it is left unchanged on errors.
 func Init(options *Options) error {
-	Viper = viper.New()
+	StopWatching()
 
-	err := loadConfigFromK8s()
+	c, source, src, err := load(options)
+	if err != nil {
+		return err
+	}
+
+	if options != nil && options.WatchConfig {
+		return watchConfig(c, source, src, options)
+	}
+	c.setGlobals()
+	return nil
+}
+
+// New loads and validates a kmux configuration, without modifying the package level variables.
+// Options.WatchConfig is not supported, the configuration is loaded once.
+func New(options *Options) (*Config, error) {
+	c, _, _, err := load(options)
+	return c, err
+}
+
+// Current returns the active configuration, i.e. the configuration loaded by Init() or
+// reloaded since then. The returned configuration must not be modified.
+func Current() *Config {
+	if c := current.Load(); c != nil {
+		return c
+	}
+
+	// Init() is not called, the package level variables may be set directly
+	return &Config{
+		App:         App,
+		Pulsar:      Pulsar,
+		Kafka:       Kafka,
+		KnoxGateway: KnoxGateway,
+		Database:    Database,
+		Vault:       Vault,
+		Codecs:      Codecs,
+		Viper:       Viper,
+	}
+}
+
+// load loads the configuration from k8s or the local config file, and returns the
+// source of the configuration
+func load(options *Options) (*Config, string, k8sSource, error) {
+	c, err := newConfig(options.getFlags())
+	if err != nil {
+		return nil, "", k8sSource{}, err
+	}
+
+	src, err := options.getK8sSource()
+	if err == nil {
+		err = c.loadConfigFromK8s(src)
+	}
+
+	source := k8sConfigSource
 	if err != nil {
 		log.Error().Msgf("Failed to load kmux configuration from k8s config-map. %s", err)
 
 		// Try loading the config from local config file
 		configFile := options.getLocalConfigFile()
 		log.Info().Msgf("Using local kmux config file %s", configFile)
-		if err = loadConfigFromFile(configFile); err != nil {
+		if err = c.loadConfigFromFile(configFile); err != nil {
 			log.Error().Msgf("Failed to load local kmux configuration. %s", err)
-			return err
+			return nil, "", src, err
 		}
+		source = fileConfigSource
 	} else {
-		log.Info().Msg("Loaded kmux configuration from k8s config-map")
+		log.Info().Msgf("Loaded kmux configuration from k8s config-map %s/%s", src.namespace, src.configMap)
 	}
 
-	printCurrentConfig()
+	c.populateConfig()
+	c.printCurrentConfig()
 
-	populateAppConfig()
-	populatePulsarConfig()
-	populateKnoxGatewayConfig()
-	populateDatabaseConfig()
-	populateVaultConfig()
+	if err = c.validate(); err != nil {
+		log.Error().Msg(err.Error())
+		return nil, "", src, err
+	}
+	return c, source, src, nil
+}
 
-	return nil
+// populateConfig populates the configuration from its viper instance
+func (c *Config) populateConfig() {
+	c.populateAppConfig()
+	c.populatePulsarConfig()
+	c.populateKafkaConfig()
+	c.populateKnoxGatewayConfig()
+	c.populateDatabaseConfig()
+	c.populateVaultConfig()
+	c.populateCodecConfig()
+}
+
+// setGlobals sets the active configuration and the package level variables to the configuration
+func (c *Config) setGlobals() {
+	current.Store(c)
+
+	App = c.App
+	Pulsar = c.Pulsar
+	Kafka = c.Kafka
+	KnoxGateway = c.KnoxGateway
+	Database = c.Database
+	Vault = c.Vault
+	Codecs = c.Codecs
+	Viper = c.Viper
 }
 
 func getK8sPodNamespace() (string, error) {
@@ -153,132 +415,367 @@ func getK8sPodNamespace() (string, error) {
 	return string(data), nil
 }
 
-func getConfigFromK8sConfigMap(namespace string) ([]byte, error) {
-	config, err := rest.InClusterConfig()
+// getK8sKubeconfigNamespace returns the namespace of the current context of the kubeconfig file,
+// or `default` if the context has no namespace
+func getK8sKubeconfigNamespace(kubeconfig string) (string, error) {
+	loader := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		&clientcmd.ClientConfigLoadingRules{ExplicitPath: kubeconfig}, &clientcmd.ConfigOverrides{})
+	ns, _, err := loader.Namespace()
+	return ns, err
+}
+
+// getK8sClientset returns the clientset using the kubeconfig file, if any.
+// Otherwise, the in-cluster configuration is used.
+func getK8sClientset(kubeconfig string) (*kubernetes.Clientset, error) {
+	var config *rest.Config
+	var err error
+	if kubeconfig != "" {
+		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+	} else {
+		config, err = rest.InClusterConfig()
+	}
 	if err != nil {
 		return nil, err
 	}
+	return kubernetes.NewForConfig(config)
+}
 
-	clientset, err := kubernetes.NewForConfig(config)
+func getConfigFromK8sConfigMap(clientset kubernetes.Interface, src k8sSource) ([]byte, error) {
+	cm, err := clientset.CoreV1().ConfigMaps(src.namespace).Get(context.TODO(), src.configMap, metav1.GetOptions{})
 	if err != nil {
 		return nil, err
 	}
 
-	cm, err := clientset.CoreV1().ConfigMaps(namespace).Get(context.TODO(), defaultK8sConfigMap, metav1.GetOptions{})
+	configYaml := cm.Data[src.configMapKey]
+	return []byte(configYaml), nil
+}
+
+func getConfigFromK8sSecret(clientset kubernetes.Interface, src k8sSource) ([]byte, error) {
+	secret, err := clientset.CoreV1().Secrets(src.namespace).Get(context.TODO(), src.secret, metav1.GetOptions{})
 	if err != nil {
 		return nil, err
 	}
 
-	configYaml := cm.Data[k8sConfigMapKey]
-	return []byte(configYaml), nil
+	configYaml, ok := secret.Data[src.secretKey]
+	if !ok {
+		return nil, fmt.Errorf("key %s not found in k8s secret %s", src.secretKey, src.secret)
+	}
+	return configYaml, nil
 }
 
-func loadConfigFromK8s() error {
-	ns, err := getK8sPodNamespace()
+func (c *Config) loadConfigFromK8s(src k8sSource) error {
+	clientset, err := getK8sClientset(src.kubeconfig)
 	if err != nil {
 		return err
 	}
 
-	configYaml, err := getConfigFromK8sConfigMap(ns)
+	configYaml, err := getConfigFromK8sConfigMap(clientset, src)
 	if err != nil {
 		return err
 	}
 
-	Viper.SetConfigType("yaml")
-	err = Viper.ReadConfig(bytes.NewBuffer(configYaml))
+	var secretYaml []byte
+	if src.secret != "" {
+		secretYaml, err = getConfigFromK8sSecret(clientset, src)
+		if err != nil {
+			return err
+		}
+	}
+
+	return c.readK8sConfig(configYaml, secretYaml)
+}
+
+// readK8sConfig reads the configuration from the config-map, and merges the
+// sensitive sections from the secret, if any
+func (c *Config) readK8sConfig(configYaml, secretYaml []byte) error {
+	c.Viper.SetConfigType("yaml")
+	err := c.Viper.ReadConfig(bytes.NewBuffer(configYaml))
 	if err != nil {
 		return err
 	}
+
+	if secretYaml != nil {
+		return c.Viper.MergeConfig(bytes.NewBuffer(secretYaml))
+	}
 	return nil
 }
 
-func loadConfigFromFile(file string) error {
-	Viper.SetConfigFile(file)
-	err := Viper.ReadInConfig()
+func (c *Config) loadConfigFromFile(file string) error {
+	c.Viper.SetConfigFile(file)
+	err := c.Viper.ReadInConfig()
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func populateAppConfig() {
-	App.Sink.StreamDriver = Viper.GetString("kmux.sink.stream")
-	App.Sink.DatabaseDriver = Viper.GetString("kmux.sink.database")
-	App.Source.StreamDriver = Viper.GetString("kmux.source.stream")
-	App.Source.DatabaseDriver = Viper.GetString("kmux.source.database")
-	App.Vault = Viper.GetString("kmux.vault")
+func (c *Config) populateAppConfig() {
+	c.App.Sink.StreamDriver = c.Viper.GetString("kmux.sink.stream")
+	c.App.Sink.DatabaseDriver = c.Viper.GetString("kmux.sink.database")
+	c.App.Source.StreamDriver = c.Viper.GetString("kmux.source.stream")
+	c.App.Source.DatabaseDriver = c.Viper.GetString("kmux.source.database")
+	c.App.Vault = c.Viper.GetString("kmux.vault")
+	c.App.Metrics.Enable = c.Viper.GetBool("kmux.metrics.enable")
+	c.App.Metrics.Namespace = c.Viper.GetString("kmux.metrics.namespace")
+	c.App.Tracing.Enable = c.Viper.GetBool("kmux.tracing.enable")
+	c.App.Log.Redact = c.Viper.GetStringSlice("kmux.log.redact")
 }
 
-func populatePulsarConfig() {
-	servers := Viper.GetStringSlice("pulsar.servers")
-	subscription := Viper.GetString("pulsar.subscription")
-	encryptEnabled := Viper.GetBool("pulsar.encryption.enable")
-	authEnabled := Viper.GetBool("pulsar.auth.enable")
+func (c *Config) populatePulsarConfig() {
+	servers := c.Viper.GetStringSlice("pulsar.servers")
+	subscription := c.Viper.GetString("pulsar.subscription")
+	encryptEnabled := c.Viper.GetBool("pulsar.encryption.enable")
+	authEnabled := c.Viper.GetBool("pulsar.auth.enable")
 
 	opt := pulsar.ClientOptions{}
 	if encryptEnabled {
 		opt.URL = fmt.Sprintf("pulsar+ssl://%s", strings.Join(servers, ","))
-		opt.TLSTrustCertsFilePath = Viper.GetString("pulsar.encryption.ca-cert")
+		opt.TLSTrustCertsFilePath = c.Viper.GetString("pulsar.encryption.ca-cert")
 		if authEnabled {
-			keyPath := Viper.GetString("pulsar.auth.key")
-			certPath := Viper.GetString("pulsar.auth.cert")
+			keyPath := c.Viper.GetString("pulsar.auth.key")
+			certPath := c.Viper.GetString("pulsar.auth.cert")
 			opt.Authentication = pulsar.NewAuthenticationTLS(certPath, keyPath)
 		}
 	} else {
 		opt.URL = fmt.Sprintf("pulsar://%s", strings.Join(servers, ","))
 	}
 
-	prefix := Viper.GetString("pulsar.topic-prefix")
+	prefix := c.Viper.GetString("pulsar.topic-prefix")
+
+	c.Pulsar = PulsarConfig{
+		TopicPrefix:      prefix,
+		Options:          opt,
+		Producer:         c.getPulsarProducerConfig(),
+		Subscription:     subscription,
+		SubscriptionType: getPulsarSubscriptionType(c.Viper.GetString("pulsar.subscription-type")),
+		Topics:           c.getPulsarTopicsConfig(),
+	}
+}
+
+// getPulsarTopicsConfig returns the topic specific configuration.
+// viper keys are case insensitive, hence the topic names are lower cased.
+func (c *Config) getPulsarTopicsConfig() map[string]PulsarTopicConfig {
+	topics := map[string]PulsarTopicConfig{}
+	for topic := range c.Viper.GetStringMap("pulsar.topics") {
+		key := "pulsar.topics." + topic
+		topicConfig := PulsarTopicConfig{}
+		if c.Viper.IsSet(key + ".schema") {
+			topicConfig.Schema = &PulsarSchemaConfig{
+				Type:           c.Viper.GetString(key + ".schema.type"),
+				Definition:     c.Viper.GetString(key + ".schema.definition"),
+				DefinitionFile: c.Viper.GetString(key + ".schema.definition-file"),
+			}
+		}
+		topics[topic] = topicConfig
+	}
+	return topics
+}
+
+func (c *Config) getPulsarProducerConfig() PulsarProducerConfig {
+	producer := PulsarProducerConfig{
+		SendTimeout:             c.Viper.GetDuration("pulsar.producer.send-timeout"),
+		MaxPendingMessages:      c.Viper.GetInt("pulsar.producer.max-pending-messages"),
+		DisableBlockIfQueueFull: c.Viper.IsSet("pulsar.producer.block-if-queue-full") && !c.Viper.GetBool("pulsar.producer.block-if-queue-full"),
+		DisableBatching:         c.Viper.GetBool("pulsar.producer.batching.disable"),
+		BatchingMaxPublishDelay: c.Viper.GetDuration("pulsar.producer.batching.max-publish-delay"),
+		BatchingMaxMessages:     c.Viper.GetUint("pulsar.producer.batching.max-messages"),
+		BatchingMaxSize:         c.Viper.GetUint("pulsar.producer.batching.max-size"),
+	}
+	if producer.MaxPendingMessages == 0 {
+		producer.MaxPendingMessages = defaultPulsarMaxPendingMessages
+	}
+	if producer.BatchingMaxSize == 0 {
+		producer.BatchingMaxSize = defaultPulsarBatchingMaxSize
+	}
+	return producer
+}
 
-	Pulsar = PulsarConfig{
-		TopicPrefix:  prefix,
-		Options:      opt,
-		Subscription: subscription,
+func getPulsarSubscriptionType(subType string) pulsar.SubscriptionType {
+	switch subType {
+	case "", "exclusive":
+		return pulsar.Exclusive
+	case "shared":
+		return pulsar.Shared
+	case "failover":
+		return pulsar.Failover
+	case "key-shared":
+		return pulsar.KeyShared
 	}
+	log.Error().Msgf("Invalid pulsar subscription type %s. Using exclusive subscription", subType)
+	return pulsar.Exclusive
 }
 
-func populateDatabaseConfig() {
-	Database = DatabaseConfig{
-		Server:     Viper.GetString("database.server"),
-		Name:       Viper.GetString("database.name"),
-		ConnParams: Viper.GetStringSlice("database.connectionParams"),
+func (c *Config) populateKafkaConfig() {
+	c.Kafka = KafkaConfig{
+		Brokers:     c.Viper.GetStringSlice("kafka.brokers"),
+		TopicPrefix: c.Viper.GetString("kafka.topic-prefix"),
+		Version:     c.Viper.GetString("kafka.version"),
+		Acks:        c.Viper.GetString("kafka.acks"),
+		Compression: c.Viper.GetString("kafka.compression"),
 	}
-	if Viper.Get("database.vault") == nil {
-		Database.Username = Viper.GetString("database.username")
-		Database.Password = Viper.GetString("database.password")
-		Database.Vault = nil
+
+	if c.Viper.GetBool("kafka.encryption.enable") {
+		c.Kafka.TLS = &KafkaTLSConfig{
+			CACert: c.Viper.GetString("kafka.encryption.ca-cert"),
+		}
+		if c.Viper.GetBool("kafka.auth.enable") {
+			c.Kafka.TLS.Cert = c.Viper.GetString("kafka.auth.cert")
+			c.Kafka.TLS.Key = c.Viper.GetString("kafka.auth.key")
+		}
+	}
+
+	if c.Viper.GetBool("kafka.sasl.enable") {
+		c.Kafka.SASL = &KafkaSASLConfig{
+			Mechanism: c.Viper.GetString("kafka.sasl.mechanism"),
+			Username:  c.Viper.GetString("kafka.sasl.username"),
+			Password:  c.Viper.GetString("kafka.sasl.password"),
+		}
+	}
+}
+
+func (c *Config) populateDatabaseConfig() {
+	c.Database = DatabaseConfig{
+		Server:     c.Viper.GetString("database.server"),
+		Name:       c.Viper.GetString("database.name"),
+		ConnParams: c.Viper.GetStringSlice("database.connectionParams"),
+	}
+	if c.Viper.Get("database.vault") == nil {
+		c.Database.Username = c.Viper.GetString("database.username")
+		c.Database.Password = c.Viper.GetString("database.password")
+		c.Database.Vault = nil
 	} else {
-		Database.Username = ""
-		Database.Password = ""
-		Database.Vault = &DatabaseVault{
-			SecretPath: Viper.GetString("database.vault.secretPath"),
+		c.Database.Username = ""
+		c.Database.Password = ""
+		c.Database.Vault = &DatabaseVault{
+			SecretPath: c.Viper.GetString("database.vault.secretPath"),
 			Key: DatabaseVaultKey{
-				Username: Viper.GetString("database.vault.key.username"),
-				Password: Viper.GetString("database.vault.key.password"),
+				Username: c.Viper.GetString("database.vault.key.username"),
+				Password: c.Viper.GetString("database.vault.key.password"),
 			},
 		}
 	}
 }
 
-func populateVaultConfig() {
-	Vault = HashiVaultConfig{
-		Server: Viper.GetString("vault.server"),
+func (c *Config) populateVaultConfig() {
+	c.Vault = HashiVaultConfig{
+		Server:    c.Viper.GetString("vault.server"),
+		Namespace: c.Viper.GetString("vault.namespace"),
+		KVVersion: c.Viper.GetInt("vault.kv-version"),
+		KVMount:   c.Viper.GetString("vault.kv-mount"),
+		Auth: HashiVaultAuthConfig{
+			Method:   c.Viper.GetString("vault.auth.method"),
+			Mount:    c.Viper.GetString("vault.auth.mount"),
+			Token:    c.Viper.GetString("vault.auth.token"),
+			Role:     c.Viper.GetString("vault.auth.role"),
+			JWTPath:  c.Viper.GetString("vault.auth.jwt-path"),
+			RoleID:   c.Viper.GetString("vault.auth.role-id"),
+			SecretID: c.Viper.GetString("vault.auth.secret-id"),
+		},
+	}
+	if c.Vault.KVVersion == 0 {
+		c.Vault.KVVersion = 2
+	}
+	if c.Vault.Auth.Token == "" {
+		c.Vault.Auth.Token = os.Getenv("VAULT_TOKEN")
 	}
 }
 
-func populateKnoxGatewayConfig() {
-	KnoxGateway = KnoxGatewayConfig{
-		Server: Viper.GetString("knox-gateway.server"),
+func (c *Config) populateCodecConfig() {
+	c.Codecs = CodecsConfig{
+		Default: c.Viper.GetString("codecs.default"),
+		Topics:  map[string]CodecConfig{},
+	}
+
+	// viper keys are case insensitive, hence the topic names are lower cased
+	for topic := range c.Viper.GetStringMap("codecs.topics") {
+		key := "codecs.topics." + topic
+		c.Codecs.Topics[topic] = CodecConfig{
+			Codec:      c.Viper.GetString(key + ".codec"),
+			Schema:     c.Viper.GetString(key + ".schema"),
+			SchemaFile: c.Viper.GetString(key + ".schema-file"),
+		}
 	}
 }
-func printCurrentConfig() {
-	allKeys := Viper.AllKeys()
 
-	configArr := []string{}
+func (c *Config) populateKnoxGatewayConfig() {
+	// the reconnect defaults are only set when the gateway is configured,
+	// so that they are not logged in the configuration of the other drivers
+	configured := c.knoxGatewayConfigured()
+	if configured {
+		c.Viper.SetDefault("knox-gateway.reconnect.initial-interval", defaultKnoxGatewayReconnectInitialInterval)
+		c.Viper.SetDefault("knox-gateway.reconnect.max-interval", defaultKnoxGatewayReconnectMaxInterval)
+		c.Viper.SetDefault("knox-gateway.reconnect.multiplier", defaultKnoxGatewayReconnectMultiplier)
+		c.Viper.SetDefault("knox-gateway.reconnect.jitter", defaultKnoxGatewayReconnectJitter)
+		c.Viper.SetDefault("knox-gateway.reconnect.buffer-size", defaultKnoxGatewayReconnectBufferSize)
+		c.Viper.SetDefault("knox-gateway.reconnect.flush-timeout", defaultKnoxGatewayReconnectFlushTimeout)
+	}
+
+	c.KnoxGateway = KnoxGatewayConfig{
+		Server: c.Viper.GetString("knox-gateway.server"),
+		Reconnect: KnoxGatewayReconnectConfig{
+			InitialInterval: c.Viper.GetDuration("knox-gateway.reconnect.initial-interval"),
+			MaxInterval:     c.Viper.GetDuration("knox-gateway.reconnect.max-interval"),
+			Multiplier:      c.Viper.GetFloat64("knox-gateway.reconnect.multiplier"),
+			Jitter:          c.Viper.GetFloat64("knox-gateway.reconnect.jitter"),
+			MaxAttempts:     c.Viper.GetInt("knox-gateway.reconnect.max-attempts"),
+			BufferSize:      c.Viper.GetInt("knox-gateway.reconnect.buffer-size"),
+			FlushTimeout:    c.Viper.GetDuration("knox-gateway.reconnect.flush-timeout"),
+		},
+		Token: c.Viper.GetString("knox-gateway.auth.token"),
+	}
+
+	// the sinks created with a server address still reconnect with the defaults
+	if !configured {
+		c.KnoxGateway.Reconnect = KnoxGatewayReconnectConfig{
+			InitialInterval: defaultKnoxGatewayReconnectInitialInterval,
+			MaxInterval:     defaultKnoxGatewayReconnectMaxInterval,
+			Multiplier:      defaultKnoxGatewayReconnectMultiplier,
+			Jitter:          defaultKnoxGatewayReconnectJitter,
+			BufferSize:      defaultKnoxGatewayReconnectBufferSize,
+			FlushTimeout:    defaultKnoxGatewayReconnectFlushTimeout,
+		}
+	}
+
+	if c.Viper.GetBool("knox-gateway.encryption.enable") {
+		c.KnoxGateway.TLS = &KnoxGatewayTLSConfig{
+			CACert:     c.Viper.GetString("knox-gateway.encryption.ca-cert"),
+			ServerName: c.Viper.GetString("knox-gateway.encryption.server-name"),
+		}
+		if c.Viper.GetBool("knox-gateway.auth.enable") {
+			c.KnoxGateway.TLS.Cert = c.Viper.GetString("knox-gateway.auth.cert")
+			c.KnoxGateway.TLS.Key = c.Viper.GetString("knox-gateway.auth.key")
+		}
+	}
+}
+
+// knoxGatewayConfigured returns true if any of the knox-gateway keys is set,
+// in the configuration file, the environment or the flags
+func (c *Config) knoxGatewayConfigured() bool {
+	for _, key := range knownKeys {
+		if strings.HasPrefix(key, "knox-gateway.") && c.Viper.IsSet(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// printCurrentConfig logs the configuration as the `config` field, with the values of the
+// sensitive keys, and the sensitive `key=value` entries of the values, redacted.
+// The configuration must be populated.
+func (c *Config) printCurrentConfig() {
+	r := newRedactor(c.App.Log.Redact)
+
+	allKeys := c.Viper.AllKeys()
+	sort.Strings(allKeys)
+
+	fields := zerolog.Dict()
 	for _, key := range allKeys {
-		value := Viper.Get(key)
-		configArr = append(configArr, fmt.Sprintf("%s=%v", key, value))
+		if r.redact(key) {
+			fields.Str(key, redactedValue)
+			continue
+		}
+		fields.Interface(key, r.redactValue(c.Viper.Get(key)))
 	}
 
-	log.Info().Msgf("Kmux current configuration - [%s]", strings.Join(configArr, ", "))
+	log.Info().Dict("config", fields).Msg("Kmux current configuration")
 }
@@ -0,0 +1,56 @@
+package config
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	driversMu sync.RWMutex
+
+	// customSinkDrivers are the stream sink drivers registered in addition to the built-in drivers
+	customSinkDrivers = map[string]bool{}
+)
+
+// RegisterSinkStreamDriver allows `kmux.sink.stream` to select the stream sink driver, and
+// the keys of its configuration section, i.e. `nats.*` for the `nats` driver. Registering
+// a built-in driver has no effect. It is called by stream.RegisterSinkDriver().
+func RegisterSinkStreamDriver(name string) {
+	switch name {
+	case PulsarDriver, KafkaDriver, KnoxGatewayDriver:
+		return
+	}
+
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	customSinkDrivers[name] = true
+}
+
+// sinkStreamDrivers returns the built-in and the registered stream sink drivers
+func sinkStreamDrivers() []string {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+
+	custom := make([]string, 0, len(customSinkDrivers))
+	for name := range customSinkDrivers {
+		custom = append(custom, name)
+	}
+	sort.Strings(custom)
+	return append([]string{PulsarDriver, KafkaDriver, KnoxGatewayDriver}, custom...)
+}
+
+// isCustomDriverKey returns true if the key belongs to the configuration section of a
+// registered driver. The section is validated by the driver itself.
+func isCustomDriverKey(key string) bool {
+	section, _, _ := strings.Cut(key, ".")
+
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+	for name := range customSinkDrivers {
+		if strings.ToLower(name) == section {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+package config
+
+import (
+	"strings"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+const (
+	// ConfigFileFlag is the command line flag overriding the path of the local config file
+	ConfigFileFlag = "kmux-config"
+
+	// envPrefix is the prefix of the environment variables overriding configuration keys,
+	// i.e. `KMUX_PULSAR_SERVERS` overrides `pulsar.servers`
+	envPrefix = "KMUX"
+
+	// flagKeyAnnotation is the flag annotation holding the configuration key overridden by the flag
+	flagKeyAnnotation = "kmux-config-key"
+)
+
+// BindFlags adds the `--kmux-config <file-path>` flag to the flag set, pflag.CommandLine when nil.
+// The parsed flag set should be passed as Options.Flags.
+func BindFlags(fs *pflag.FlagSet) {
+	if fs == nil {
+		fs = pflag.CommandLine
+	}
+	if fs.Lookup(ConfigFileFlag) == nil {
+		fs.String(ConfigFileFlag, defaultConfigFile, "kmux local configuration file")
+	}
+}
+
+// BindFlag makes the flag of the flag set override the configuration key, i.e.
+// `BindFlag(fs, "pulsar-servers", "pulsar.servers")`. The flag is used only when set
+// on the command line.
+func BindFlag(fs *pflag.FlagSet, name, key string) error {
+	return fs.SetAnnotation(name, flagKeyAnnotation, []string{key})
+}
+
+// newConfig returns an empty configuration whose viper instance reads the
+// environment variables and the flags overriding the configuration keys
+func newConfig(flags *pflag.FlagSet) (*Config, error) {
+	v, err := newViper(envPrefix, flags, "")
+	if err != nil {
+		return nil, err
+	}
+	return &Config{Viper: v, flags: flags}, nil
+}
+
+// Sub returns the configuration section as a viper instance, i.e. `nats` for the `nats`
+// sink driver. The keys of the section are overridden by the environment variables and
+// the flags the same way as the whole configuration, i.e. `KMUX_NATS_URL` overrides `url`.
+// An empty viper instance is returned if the section is not configured.
+func (c *Config) Sub(section string) (*viper.Viper, error) {
+	// viper keys are case insensitive
+	section = strings.ToLower(section)
+
+	sub, err := newViper(envPrefix+"_"+section, c.flags, section+".")
+	if err != nil {
+		return nil, err
+	}
+	if c.Viper != nil {
+		if settings := c.Viper.GetStringMap(section); len(settings) > 0 {
+			if err = sub.MergeConfigMap(settings); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return sub, nil
+}
+
+// newViper returns a viper instance reading the environment variables with the prefix,
+// and the flags overriding the configuration keys with the key prefix
+func newViper(prefix string, flags *pflag.FlagSet, keyPrefix string) (*viper.Viper, error) {
+	v := viper.New()
+	v.SetEnvPrefix(prefix)
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_", "-", "_"))
+	v.AutomaticEnv()
+
+	if flags == nil {
+		return v, nil
+	}
+
+	var err error
+	flags.VisitAll(func(f *pflag.Flag) {
+		keys := f.Annotations[flagKeyAnnotation]
+		if err != nil || len(keys) == 0 || !strings.HasPrefix(keys[0], keyPrefix) {
+			return
+		}
+		err = v.BindPFlag(strings.TrimPrefix(keys[0], keyPrefix), f)
+	})
+	return v, err
+}
+
+// getConfigFileFlag returns the value of the `--kmux-config` flag, if set on the command line
+func getConfigFileFlag(flags *pflag.FlagSet) string {
+	if flags == nil {
+		return ""
+	}
+	f := flags.Lookup(ConfigFileFlag)
+	if f == nil || !f.Changed {
+		return ""
+	}
+	return f.Value.String()
+}
@@ -1,17 +1,147 @@
 package config
 
+import (
+	"os"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/pflag"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
 const (
-	defaultConfigFile = "kmux-config.yaml"
+	defaultConfigFile   = "kmux-config.yaml"
+	defaultK8sConfigMap = "kmux"
+	defaultK8sConfigKey = "config.yaml"
+)
+
+// Environment variables used when the corresponding options are not set
+const (
+	envK8sConfigMap    = "KMUX_CONFIGMAP_NAME"
+	envK8sConfigMapKey = "KMUX_CONFIGMAP_KEY"
+	envK8sNamespace    = "KMUX_NAMESPACE"
+	envK8sKubeconfig   = "KMUX_KUBECONFIG"
+	envK8sSecret       = "KMUX_SECRET_NAME"
+	envK8sSecretKey    = "KMUX_SECRET_KEY"
 )
 
 // Options contains kmux initialization options
 type Options struct {
+	// LocalConfigFile is the path of the local config file, used when the k8s config-map
+	// can not be loaded. Overridden by the `--kmux-config` flag. Defaults to `kmux-config.yaml`.
 	LocalConfigFile string
+
+	// Flags is the parsed command line flag set. See BindFlags() and BindFlag().
+	Flags *pflag.FlagSet
+
+	// ConfigMapName is the name of the k8s config-map containing kmux configuration.
+	// Defaults to $KMUX_CONFIGMAP_NAME, or `kmux`.
+	ConfigMapName string
+
+	// ConfigMapKey is the config-map key containing kmux configuration.
+	// Defaults to $KMUX_CONFIGMAP_KEY, or `config.yaml`.
+	ConfigMapKey string
+
+	// Namespace is the namespace of the config-map and the secret.
+	// Defaults to $KMUX_NAMESPACE, or the namespace of the current kubeconfig
+	// context when Kubeconfig is set, or the namespace of the pod.
+	Namespace string
+
+	// Kubeconfig is the path of the kubeconfig file used to connect with k8s
+	// out of the cluster. Defaults to $KMUX_KUBECONFIG, or the in-cluster configuration.
+	Kubeconfig string
+
+	// SecretName is the name of an optional k8s secret containing the sensitive
+	// sections of kmux configuration, which are merged over the config-map.
+	// Defaults to $KMUX_SECRET_NAME.
+	SecretName string
+
+	// SecretKey is the secret key containing kmux configuration.
+	// Defaults to $KMUX_SECRET_KEY, or `config.yaml`.
+	SecretKey string
+
+	// WatchConfig enables reloading the configuration whenever the k8s config-map
+	// or the local config file changes. See Subscribe().
+	WatchConfig bool
+
+	// MetricsRegisterer is used to register kmux metrics when `kmux.metrics.enable`
+	// is set. prometheus.DefaultRegisterer is used when not specified.
+	MetricsRegisterer prometheus.Registerer
+
+	// TracerProvider and Propagator are used to trace the messages when `kmux.tracing.enable`
+	// is set. The global tracer provider and the W3C trace context propagator are used when not specified.
+	TracerProvider trace.TracerProvider
+	Propagator     propagation.TextMapPropagator
 }
 
 func (o *Options) getLocalConfigFile() string {
-	if o == nil || o.LocalConfigFile == "" {
+	if o == nil {
+		return defaultConfigFile
+	}
+	if file := getConfigFileFlag(o.Flags); file != "" {
+		return file
+	}
+	if o.LocalConfigFile == "" {
 		return defaultConfigFile
 	}
 	return o.LocalConfigFile
 }
+
+func (o *Options) getFlags() *pflag.FlagSet {
+	if o == nil {
+		return nil
+	}
+	return o.Flags
+}
+
+// k8sSource describes the k8s resources containing kmux configuration
+type k8sSource struct {
+	configMap    string
+	configMapKey string
+	namespace    string
+	kubeconfig   string
+	secret       string
+	secretKey    string
+}
+
+func (o *Options) getK8sSource() (k8sSource, error) {
+	if o == nil {
+		o = &Options{}
+	}
+
+	src := k8sSource{
+		configMap:    getOption(o.ConfigMapName, envK8sConfigMap, defaultK8sConfigMap),
+		configMapKey: getOption(o.ConfigMapKey, envK8sConfigMapKey, defaultK8sConfigKey),
+		namespace:    getOption(o.Namespace, envK8sNamespace, ""),
+		kubeconfig:   getOption(o.Kubeconfig, envK8sKubeconfig, ""),
+		secret:       getOption(o.SecretName, envK8sSecret, ""),
+		secretKey:    getOption(o.SecretKey, envK8sSecretKey, defaultK8sConfigKey),
+	}
+
+	if src.namespace == "" {
+		var ns string
+		var err error
+		if src.kubeconfig != "" {
+			ns, err = getK8sKubeconfigNamespace(src.kubeconfig)
+		} else {
+			ns, err = getK8sPodNamespace()
+		}
+		if err != nil {
+			return src, err
+		}
+		src.namespace = ns
+	}
+	return src, nil
+}
+
+// getOption returns the option value if set, else the value of the environment variable if set,
+// else the default value
+func getOption(value, env, defaultValue string) string {
+	if value != "" {
+		return value
+	}
+	if value = os.Getenv(env); value != "" {
+		return value
+	}
+	return defaultValue
+}
@@ -0,0 +1,80 @@
+package config
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+// redactedValue replaces the values of the sensitive keys in the logs
+const redactedValue = "[REDACTED]"
+
+// defaultRedactPatterns match the sensitive configuration keys, i.e. `database.password`
+// and `database.vault.secretpath`. `*` matches any sequence of characters.
+var defaultRedactPatterns = []string{
+	"*password*",
+	"*secret*",
+	"*token*",
+	"*role-id*",
+	"*credential*",
+	"*private-key*",
+}
+
+// paramPattern matches the `key=value` entries of string values, i.e. the connection
+// parameters `password=pass` or `sslmode=disable password=pass`
+var paramPattern = regexp.MustCompile(`([A-Za-z0-9_.-]+)=([^\s&;,]*)`)
+
+// redactor decides whether the value of a configuration key is sensitive
+type redactor struct {
+	patterns []string
+}
+
+// newRedactor returns a redactor matching the default patterns and the given patterns
+func newRedactor(patterns []string) *redactor {
+	r := &redactor{patterns: append([]string{}, defaultRedactPatterns...)}
+	for _, pattern := range patterns {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			r.patterns = append(r.patterns, strings.ToLower(pattern))
+		}
+	}
+	return r
+}
+
+// redact returns true if the value of the key must not be logged.
+// viper keys are lower cased, and do not contain `/`, hence `*` matches across sections.
+func (r *redactor) redact(key string) bool {
+	for _, pattern := range r.patterns {
+		if matched, _ := path.Match(pattern, key); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// redactValue redacts the values of the `key=value` entries of string values, and of
+// the string elements of list values, whose key is sensitive
+func (r *redactor) redactValue(value any) any {
+	switch v := value.(type) {
+	case string:
+		return paramPattern.ReplaceAllStringFunc(v, func(param string) string {
+			name, _, _ := strings.Cut(param, "=")
+			if r.redact(strings.ToLower(name)) {
+				return name + "=" + redactedValue
+			}
+			return param
+		})
+	case []string:
+		redacted := make([]string, len(v))
+		for i, s := range v {
+			redacted[i] = r.redactValue(s).(string)
+		}
+		return redacted
+	case []any:
+		redacted := make([]any, len(v))
+		for i, e := range v {
+			redacted[i] = r.redactValue(e)
+		}
+		return redacted
+	}
+	return value
+}
@@ -0,0 +1,170 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+func TestRedact(t *testing.T) {
+	r := newRedactor([]string{" *api-key ", ""})
+
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{key: "database.password", want: true},
+		{key: "database.vault.secretpath", want: true},
+		{key: "knox-gateway.auth.token", want: true},
+		{key: "vault.auth.role-id", want: true},
+		{key: "nats.api-key", want: true},
+		{key: "database.username"},
+		{key: "database.connectionparams"},
+		{key: "kmux.log.redact"},
+	}
+	for _, tt := range tests {
+		if got := r.redact(tt.key); got != tt.want {
+			t.Errorf("redact(%q) = %t, want %t", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestRedactValue(t *testing.T) {
+	r := newRedactor([]string{"*api-key"})
+
+	tests := []struct {
+		name  string
+		value any
+		want  any
+	}{
+		{name: "plain string", value: "pulsar:6650", want: "pulsar:6650"},
+		{name: "sensitive param", value: "password=pass", want: "password=[REDACTED]"},
+		{name: "other param", value: "sslmode=disable", want: "sslmode=disable"},
+		{
+			name:  "connection string",
+			value: "host=db sslmode=disable Password=p@ss user=kmux",
+			want:  "host=db sslmode=disable Password=[REDACTED] user=kmux",
+		},
+		{
+			name:  "query string",
+			value: "authSource=admin&x-api-key=key&tls=true",
+			want:  "authSource=admin&x-api-key=[REDACTED]&tls=true",
+		},
+		{
+			name:  "list",
+			value: []any{"sslmode=disable", "password=pass", 5},
+			want:  []any{"sslmode=disable", "password=[REDACTED]", 5},
+		},
+		{
+			name:  "string list",
+			value: []string{"secret=s3cr3t", "timeout=5s"},
+			want:  []string{"secret=[REDACTED]", "timeout=5s"},
+		},
+		{name: "number", value: 42, want: 42},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _ := json.Marshal(r.redactValue(tt.value))
+			want, _ := json.Marshal(tt.want)
+			if string(got) != string(want) {
+				t.Errorf("redactValue(%v) = %s, want %s", tt.value, got, want)
+			}
+		})
+	}
+}
+
+// loggedConfig returns the configuration logged by printCurrentConfig
+func loggedConfig(t *testing.T, c *Config) map[string]any {
+	t.Helper()
+
+	var buf bytes.Buffer
+	logger := log.Logger
+	log.Logger = zerolog.New(&buf)
+	defer func() { log.Logger = logger }()
+
+	c.printCurrentConfig()
+
+	var entry struct {
+		Config map[string]any `json:"config"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("logged configuration %q: %v", buf.String(), err)
+	}
+	return entry.Config
+}
+
+func TestPrintCurrentConfig(t *testing.T) {
+	c := loadTestConfig(t, `
+kmux:
+  sink:
+    stream: pulsar
+    database: postgres
+pulsar:
+  servers: ["pulsar:6650"]
+database:
+  server: postgres:5432
+  name: kmux
+  username: kmux
+  password: database-password
+  connectionparams: ["sslmode=disable", "password=param-password"]
+`)
+	logged := loggedConfig(t, c)
+
+	out, _ := json.Marshal(logged)
+	if strings.Contains(string(out), "database-password") || strings.Contains(string(out), "param-password") {
+		t.Errorf("logged configuration contains a password: %s", out)
+	}
+	if logged["database.password"] != redactedValue {
+		t.Errorf("database.password = %v, want it redacted", logged["database.password"])
+	}
+	params, _ := json.Marshal(logged["database.connectionparams"])
+	if string(params) != `["sslmode=disable","password=[REDACTED]"]` {
+		t.Errorf("database.connectionparams = %s, want the password redacted", params)
+	}
+
+	// the knox-gateway defaults are not logged, since the gateway is not configured
+	for key := range logged {
+		if strings.HasPrefix(key, "knox-gateway.") {
+			t.Errorf("%s is logged without the knox-gateway section", key)
+		}
+	}
+	if c.KnoxGateway.Reconnect.BufferSize != defaultKnoxGatewayReconnectBufferSize {
+		t.Errorf("reconnect buffer size = %d, want the default", c.KnoxGateway.Reconnect.BufferSize)
+	}
+}
+
+func TestKnoxGatewayDefaults(t *testing.T) {
+	c := loadTestConfig(t, `
+kmux:
+  sink:
+    stream: knox-gateway
+knox-gateway:
+  server: gateway:8080
+  reconnect:
+    buffer-size: 10
+`)
+	logged := loggedConfig(t, c)
+
+	if logged["knox-gateway.reconnect.buffer-size"] != float64(10) || logged["knox-gateway.reconnect.flush-timeout"] == nil {
+		t.Errorf("logged reconnect settings = %v %v, want the configured and the default values",
+			logged["knox-gateway.reconnect.buffer-size"], logged["knox-gateway.reconnect.flush-timeout"])
+	}
+	want := KnoxGatewayReconnectConfig{
+		InitialInterval: defaultKnoxGatewayReconnectInitialInterval,
+		MaxInterval:     defaultKnoxGatewayReconnectMaxInterval,
+		Multiplier:      defaultKnoxGatewayReconnectMultiplier,
+		Jitter:          defaultKnoxGatewayReconnectJitter,
+		BufferSize:      10,
+		FlushTimeout:    defaultKnoxGatewayReconnectFlushTimeout,
+	}
+	if c.KnoxGateway.Reconnect != want {
+		t.Errorf("reconnect = %+v, want %+v", c.KnoxGateway.Reconnect, want)
+	}
+	if got := validationErrors(t, c); len(got) != 0 {
+		t.Errorf("validate() errors = %v, want none", got)
+	}
+}
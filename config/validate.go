@@ -0,0 +1,367 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// FieldError describes a problem with a single configuration key
+type FieldError struct {
+	Key     string
+	Message string
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Key, e.Message)
+}
+
+// ValidationError lists every problem found while validating kmux configuration
+type ValidationError struct {
+	Errors []*FieldError
+}
+
+func (e *ValidationError) Error() string {
+	problems := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		problems[i] = err.Error()
+	}
+	return fmt.Sprintf("invalid kmux configuration - [%s]", strings.Join(problems, ", "))
+}
+
+func (e *ValidationError) add(key, format string, args ...any) {
+	e.Errors = append(e.Errors, &FieldError{Key: key, Message: fmt.Sprintf(format, args...)})
+}
+
+// knownKeys are the configuration keys read by kmux. `*` matches the name of a topic.
+// viper keys are case insensitive, hence the keys are lower cased.
+var knownKeys = []string{
+	"kmux.sink.stream",
+	"kmux.sink.database",
+	"kmux.source.stream",
+	"kmux.source.database",
+	"kmux.vault",
+	"kmux.metrics.enable",
+	"kmux.metrics.namespace",
+	"kmux.tracing.enable",
+	"kmux.log.redact",
+
+	"pulsar.servers",
+	"pulsar.topic-prefix",
+	"pulsar.subscription",
+	"pulsar.subscription-type",
+	"pulsar.encryption.enable",
+	"pulsar.encryption.ca-cert",
+	"pulsar.auth.enable",
+	"pulsar.auth.cert",
+	"pulsar.auth.key",
+	"pulsar.producer.send-timeout",
+	"pulsar.producer.max-pending-messages",
+	"pulsar.producer.block-if-queue-full",
+	"pulsar.producer.batching.disable",
+	"pulsar.producer.batching.max-publish-delay",
+	"pulsar.producer.batching.max-messages",
+	"pulsar.producer.batching.max-size",
+	"pulsar.topics.*.schema.type",
+	"pulsar.topics.*.schema.definition",
+	"pulsar.topics.*.schema.definition-file",
+
+	"kafka.brokers",
+	"kafka.topic-prefix",
+	"kafka.version",
+	"kafka.acks",
+	"kafka.compression",
+	"kafka.encryption.enable",
+	"kafka.encryption.ca-cert",
+	"kafka.auth.enable",
+	"kafka.auth.cert",
+	"kafka.auth.key",
+	"kafka.sasl.enable",
+	"kafka.sasl.mechanism",
+	"kafka.sasl.username",
+	"kafka.sasl.password",
+
+	"knox-gateway.server",
+	"knox-gateway.encryption.enable",
+	"knox-gateway.encryption.ca-cert",
+	"knox-gateway.encryption.server-name",
+	"knox-gateway.auth.enable",
+	"knox-gateway.auth.cert",
+	"knox-gateway.auth.key",
+	"knox-gateway.auth.token",
+	"knox-gateway.reconnect.initial-interval",
+	"knox-gateway.reconnect.max-interval",
+	"knox-gateway.reconnect.multiplier",
+	"knox-gateway.reconnect.jitter",
+	"knox-gateway.reconnect.max-attempts",
+	"knox-gateway.reconnect.buffer-size",
+	"knox-gateway.reconnect.flush-timeout",
+
+	"database.server",
+	"database.name",
+	"database.username",
+	"database.password",
+	"database.connectionparams",
+	"database.vault.secretpath",
+	"database.vault.key.username",
+	"database.vault.key.password",
+
+	"vault.server",
+	"vault.namespace",
+	"vault.kv-version",
+	"vault.kv-mount",
+	"vault.auth.method",
+	"vault.auth.mount",
+	"vault.auth.token",
+	"vault.auth.role",
+	"vault.auth.jwt-path",
+	"vault.auth.role-id",
+	"vault.auth.secret-id",
+
+	"codecs.default",
+	"codecs.topics.*.codec",
+	"codecs.topics.*.schema",
+	"codecs.topics.*.schema-file",
+}
+
+// validate checks the configuration of the selected drivers, and returns
+// a *ValidationError listing every problem found
+func (c *Config) validate() error {
+	verr := &ValidationError{}
+
+	c.validateKeys(verr)
+	c.validateApp(verr)
+
+	if c.App.Sink.StreamDriver == PulsarDriver || c.App.Source.StreamDriver == PulsarDriver {
+		c.validatePulsar(verr)
+	}
+	if c.App.Sink.StreamDriver == KafkaDriver {
+		c.validateKafka(verr)
+	}
+	if c.App.Sink.StreamDriver == KnoxGatewayDriver {
+		c.validateKnoxGateway(verr)
+	}
+	if c.App.Sink.DatabaseDriver != "" || c.App.Source.DatabaseDriver != "" {
+		c.validateDatabase(verr)
+	}
+	if c.App.Vault == HashiVaultDriver {
+		c.validateVault(verr)
+	}
+	c.validateCodecs(verr)
+
+	if len(verr.Errors) > 0 {
+		return verr
+	}
+	return nil
+}
+
+func (c *Config) validateKeys(verr *ValidationError) {
+	for _, key := range c.Viper.AllKeys() {
+		if !isKnownKey(key) && !isCustomDriverKey(key) {
+			verr.add(key, "unknown key")
+		}
+	}
+}
+
+func isKnownKey(key string) bool {
+	parts := strings.Split(key, ".")
+	for _, known := range knownKeys {
+		knownParts := strings.Split(known, ".")
+		if len(knownParts) != len(parts) {
+			continue
+		}
+
+		match := true
+		for i := range parts {
+			if knownParts[i] != "*" && knownParts[i] != parts[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Config) validateApp(verr *ValidationError) {
+	validateOneOf(verr, "kmux.sink.stream", c.App.Sink.StreamDriver, sinkStreamDrivers()...)
+	validateOneOf(verr, "kmux.source.stream", c.App.Source.StreamDriver, PulsarDriver)
+	validateOneOf(verr, "kmux.sink.database", c.App.Sink.DatabaseDriver, MySQLDriver, PostgreSQLDriver, SQLiteDriver, MongoDBDriver)
+	validateOneOf(verr, "kmux.source.database", c.App.Source.DatabaseDriver, MySQLDriver, PostgreSQLDriver, SQLiteDriver, MongoDBDriver)
+	validateOneOf(verr, "kmux.vault", c.App.Vault, HashiVaultDriver)
+}
+
+func (c *Config) validatePulsar(verr *ValidationError) {
+	if len(c.Viper.GetStringSlice("pulsar.servers")) == 0 {
+		verr.add("pulsar.servers", "is required")
+	}
+	if c.App.Source.StreamDriver == PulsarDriver && c.Viper.GetString("pulsar.subscription") == "" {
+		verr.add("pulsar.subscription", "is required by pulsar source")
+	}
+	validateOneOf(verr, "pulsar.subscription-type", c.Viper.GetString("pulsar.subscription-type"),
+		"exclusive", "shared", "failover", "key-shared")
+	c.validateTLS(verr, "pulsar")
+
+	for topic, opt := range c.Pulsar.Topics {
+		if opt.Schema == nil {
+			continue
+		}
+		key := "pulsar.topics." + topic + ".schema"
+		validateOneOf(verr, key+".type", strings.ToLower(opt.Schema.Type), "json", "avro", "protobuf")
+		if opt.Schema.Definition == "" && opt.Schema.DefinitionFile == "" {
+			verr.add(key+".definition", "either definition or definition-file is required")
+		}
+		c.validateFile(verr, key+".definition-file")
+	}
+}
+
+func (c *Config) validateKafka(verr *ValidationError) {
+	if len(c.Viper.GetStringSlice("kafka.brokers")) == 0 {
+		verr.add("kafka.brokers", "is required")
+	}
+	// acks and compression are case insensitive, as in the kafka sink
+	validateOneOf(verr, "kafka.acks", strings.ToLower(c.Viper.GetString("kafka.acks")), "all", "leader", "none")
+	validateOneOf(verr, "kafka.compression", strings.ToLower(c.Viper.GetString("kafka.compression")),
+		"none", "gzip", "snappy", "lz4", "zstd")
+	c.validateTLS(verr, "kafka")
+
+	if c.Viper.GetBool("kafka.sasl.enable") {
+		validateOneOf(verr, "kafka.sasl.mechanism", strings.ToUpper(c.Viper.GetString("kafka.sasl.mechanism")),
+			"PLAIN", "SCRAM-SHA-256", "SCRAM-SHA-512")
+		c.validateRequired(verr, "kafka.sasl.username")
+		c.validateRequired(verr, "kafka.sasl.password")
+	}
+}
+
+func (c *Config) validateKnoxGateway(verr *ValidationError) {
+	c.validateRequired(verr, "knox-gateway.server")
+	c.validateTLS(verr, "knox-gateway")
+	if c.Viper.GetString("knox-gateway.auth.token") != "" && !c.Viper.GetBool("knox-gateway.encryption.enable") {
+		verr.add("knox-gateway.auth.token", "requires knox-gateway.encryption.enable, the token can not be sent in plaintext")
+	}
+
+	// the populated settings are validated, since they include the defaults
+	reconnect := c.KnoxGateway.Reconnect
+	if reconnect.InitialInterval <= 0 {
+		verr.add("knox-gateway.reconnect.initial-interval", "should be a positive duration")
+	}
+	if reconnect.BufferSize <= 0 {
+		verr.add("knox-gateway.reconnect.buffer-size", "should be a positive number")
+	}
+	if reconnect.FlushTimeout <= 0 {
+		verr.add("knox-gateway.reconnect.flush-timeout", "should be a positive duration")
+	}
+	if reconnect.Multiplier < 1 {
+		verr.add("knox-gateway.reconnect.multiplier", "should be at least 1")
+	}
+	if reconnect.Jitter < 0 || reconnect.Jitter > 1 {
+		verr.add("knox-gateway.reconnect.jitter", "should be between 0 and 1")
+	}
+}
+
+func (c *Config) validateDatabase(verr *ValidationError) {
+	c.validateRequired(verr, "database.name")
+	if c.App.Sink.DatabaseDriver != SQLiteDriver && c.App.Source.DatabaseDriver != SQLiteDriver {
+		c.validateRequired(verr, "database.server")
+	}
+
+	if c.Viper.IsSet("database.vault") {
+		if c.App.Vault == "" {
+			verr.add("database.vault", "requires kmux.vault to be configured")
+		}
+		c.validateRequired(verr, "database.vault.secretPath")
+	}
+}
+
+func (c *Config) validateVault(verr *ValidationError) {
+	c.validateRequired(verr, "vault.server")
+	if kv := c.Viper.GetInt("vault.kv-version"); kv != 0 && kv != 1 && kv != 2 {
+		verr.add("vault.kv-version", "should be 1 or 2")
+	}
+
+	switch c.Viper.GetString("vault.auth.method") {
+	case "", "token":
+		if c.Vault.Auth.Token == "" {
+			verr.add("vault.auth.token", "is required by token auth, or set VAULT_TOKEN")
+		}
+	case "kubernetes":
+		c.validateRequired(verr, "vault.auth.role")
+	case "approle":
+		c.validateRequired(verr, "vault.auth.role-id")
+		c.validateRequired(verr, "vault.auth.secret-id")
+	default:
+		verr.add("vault.auth.method", "should be one of [token kubernetes approle]")
+	}
+}
+
+func (c *Config) validateCodecs(verr *ValidationError) {
+	codecs := []string{"json", "protobuf", "avro", "msgpack"}
+
+	// the codec names are case insensitive, as in stream.NewCodec()
+	def := strings.ToLower(c.Codecs.Default)
+	if def == "avro" {
+		verr.add("codecs.default", "avro requires a schema, configure it per topic")
+	}
+	validateOneOf(verr, "codecs.default", def, codecs...)
+
+	for topic, opt := range c.Codecs.Topics {
+		key := "codecs.topics." + topic
+		codec := strings.ToLower(opt.Codec)
+		validateOneOf(verr, key+".codec", codec, codecs...)
+		if codec == "avro" && opt.Schema == "" && opt.SchemaFile == "" {
+			verr.add(key+".schema", "either schema or schema-file is required by avro codec")
+		}
+		c.validateFile(verr, key+".schema-file")
+	}
+}
+
+// validateTLS validates the encryption and auth sections of the driver
+func (c *Config) validateTLS(verr *ValidationError, driver string) {
+	encryption := c.Viper.GetBool(driver + ".encryption.enable")
+	auth := c.Viper.GetBool(driver + ".auth.enable")
+
+	if encryption {
+		c.validateFile(verr, driver+".encryption.ca-cert")
+	}
+	if auth && !encryption {
+		verr.add(driver+".auth.enable", "requires %s.encryption.enable", driver)
+	}
+	if auth && encryption {
+		c.validateRequired(verr, driver+".auth.cert")
+		c.validateRequired(verr, driver+".auth.key")
+		c.validateFile(verr, driver+".auth.cert")
+		c.validateFile(verr, driver+".auth.key")
+	}
+}
+
+func (c *Config) validateRequired(verr *ValidationError, key string) {
+	if c.Viper.GetString(key) == "" {
+		verr.add(key, "is required")
+	}
+}
+
+// validateFile checks the existence of the file, if configured
+func (c *Config) validateFile(verr *ValidationError, key string) {
+	path := c.Viper.GetString(key)
+	if path == "" {
+		return
+	}
+	if _, err := os.Stat(path); err != nil {
+		verr.add(key, "file %s is not accessible. %s", path, err)
+	}
+}
+
+// validateOneOf checks that the value, if set, is one of the allowed values
+func validateOneOf(verr *ValidationError, key, value string, allowed ...string) {
+	if value == "" {
+		return
+	}
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	verr.add(key, "%s should be one of %v", value, allowed)
+}
@@ -0,0 +1,249 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// loadTestConfig loads and populates the configuration from the yaml, without validating it
+func loadTestConfig(t *testing.T, yaml string) *Config {
+	t.Helper()
+
+	file := filepath.Join(t.TempDir(), "kmux-config.yaml")
+	if err := os.WriteFile(file, []byte(yaml), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := newConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.loadConfigFromFile(file); err != nil {
+		t.Fatalf("loadConfigFromFile() = %v", err)
+	}
+	c.populateConfig()
+	return c
+}
+
+// validationErrors returns the keys of the field errors returned by validate()
+func validationErrors(t *testing.T, c *Config) []string {
+	t.Helper()
+
+	err := c.validate()
+	if err == nil {
+		return nil
+	}
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("validate() = %T %v, want *ValidationError", err, err)
+	}
+
+	keys := make([]string, len(verr.Errors))
+	for i, ferr := range verr.Errors {
+		keys[i] = ferr.Key
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		want []string
+	}{
+		{
+			name: "valid pulsar",
+			yaml: `
+kmux:
+  sink:
+    stream: pulsar
+pulsar:
+  servers: ["pulsar:6650"]
+`,
+		},
+		{
+			name: "mixed case values",
+			yaml: `
+kmux:
+  sink:
+    stream: kafka
+kafka:
+  brokers: ["kafka:9092"]
+  acks: All
+  compression: ZSTD
+  sasl:
+    enable: true
+    mechanism: scram-sha-256
+    username: kmux
+    password: pass
+codecs:
+  default: MsgPack
+  topics:
+    alerts:
+      codec: Avro
+      schema: "{}"
+`,
+		},
+		{
+			name: "mixed case pulsar schema type",
+			yaml: `
+kmux:
+  sink:
+    stream: pulsar
+pulsar:
+  servers: ["pulsar:6650"]
+  topics:
+    events:
+      schema:
+        type: JSON
+        definition: "{}"
+    alerts:
+      schema:
+        type: thrift
+        definition: "{}"
+`,
+			want: []string{"pulsar.topics.alerts.schema.type"},
+		},
+		{
+			name: "invalid values",
+			yaml: `
+kmux:
+  sink:
+    stream: kafka
+kafka:
+  brokers: ["kafka:9092"]
+  acks: some
+  compression: brotli
+codecs:
+  default: AVRO
+`,
+			want: []string{"codecs.default", "kafka.acks", "kafka.compression"},
+		},
+		{
+			name: "aggregated errors",
+			yaml: `
+kmux:
+  sink:
+    stream: nats
+    database: postgres
+  source:
+    stream: pulsar
+pulsar:
+  subscription-type: round-robin
+`,
+			want: []string{
+				"database.name", "database.server", "kmux.sink.stream",
+				"pulsar.servers", "pulsar.subscription", "pulsar.subscription-type",
+			},
+		},
+		{
+			name: "unknown keys",
+			yaml: `
+kmux:
+  sink:
+    stream: pulsar
+  sinks:
+    stream: kafka
+pulsar:
+  servers: ["pulsar:6650"]
+  server: "pulsar:6650"
+`,
+			want: []string{"kmux.sinks.stream", "pulsar.server"},
+		},
+		{
+			name: "invalid reconnect",
+			yaml: `
+kmux:
+  sink:
+    stream: knox-gateway
+knox-gateway:
+  server: "gateway:8080"
+  reconnect:
+    buffer-size: 0
+    flush-timeout: 0s
+    jitter: 2
+`,
+			want: []string{
+				"knox-gateway.reconnect.buffer-size", "knox-gateway.reconnect.flush-timeout",
+				"knox-gateway.reconnect.jitter",
+			},
+		},
+		{
+			name: "file checks",
+			yaml: `
+kmux:
+  sink:
+    stream: knox-gateway
+knox-gateway:
+  server: "gateway:8080"
+  encryption:
+    enable: true
+    ca-cert: /nonexistent/ca.crt
+  auth:
+    enable: true
+    cert: /nonexistent/client.crt
+codecs:
+  topics:
+    alerts:
+      codec: avro
+      schema-file: /nonexistent/alerts.avsc
+`,
+			want: []string{
+				"codecs.topics.alerts.schema-file", "knox-gateway.auth.cert", "knox-gateway.auth.key",
+				"knox-gateway.encryption.ca-cert",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := validationErrors(t, loadTestConfig(t, tt.yaml))
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("validate() errors = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateExistingFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"ca.crt", "client.crt", "client.key"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("pem"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := loadTestConfig(t, `
+kmux:
+  sink:
+    stream: pulsar
+pulsar:
+  servers: ["pulsar:6650"]
+  encryption:
+    enable: true
+    ca-cert: `+filepath.Join(dir, "ca.crt")+`
+  auth:
+    enable: true
+    cert: `+filepath.Join(dir, "client.crt")+`
+    key: `+filepath.Join(dir, "client.key")+`
+`)
+	if got := validationErrors(t, c); len(got) != 0 {
+		t.Errorf("validate() errors = %v, want none", got)
+	}
+}
+
+func TestValidationErrorMessage(t *testing.T) {
+	verr := &ValidationError{}
+	verr.add("kafka.acks", "%s should be one of %v", "some", []string{"all", "leader", "none"})
+	verr.add("kafka.brokers", "is required")
+
+	want := "invalid kmux configuration - [kafka.acks: some should be one of [all leader none], kafka.brokers: is required]"
+	if got := verr.Error(); got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+}
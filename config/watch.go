@@ -0,0 +1,280 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	k8sConfigSource  = "k8s"
+	fileConfigSource = "file"
+)
+
+// ChangeEvent describes a reload of kmux configuration
+type ChangeEvent struct {
+	// Sections are the top level configuration sections changed by the reload, i.e. `knox-gateway`
+	Sections []string
+}
+
+// Changed returns true if the given top level configuration section is changed
+func (e ChangeEvent) Changed(section string) bool {
+	for _, s := range e.Sections {
+		if s == section {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	// watchMu serializes the reloads of the configuration
+	watchMu   sync.Mutex
+	settings  map[string]any
+	stopWatch chan struct{}
+
+	subscribersMu sync.Mutex
+	subscribers   = map[int]func(ChangeEvent){}
+	nextID        int
+)
+
+// Subscribe registers a function called whenever the configuration is reloaded.
+// The configuration is watched only when Options.WatchConfig is set.
+// The returned function unregisters the subscriber.
+func Subscribe(fn func(ChangeEvent)) (unsubscribe func()) {
+	subscribersMu.Lock()
+	defer subscribersMu.Unlock()
+
+	id := nextID
+	nextID++
+	subscribers[id] = fn
+
+	return func() {
+		subscribersMu.Lock()
+		defer subscribersMu.Unlock()
+		delete(subscribers, id)
+	}
+}
+
+// StopWatching stops watching the k8s config-map or the local config file for changes
+func StopWatching() {
+	watchMu.Lock()
+	defer watchMu.Unlock()
+
+	if stopWatch != nil {
+		close(stopWatch)
+		stopWatch = nil
+	}
+}
+
+// watchConfig starts watching the configuration source, and installs the configuration
+// once watching. The reloads wait for watchMu, hence they apply on top of the configuration.
+func watchConfig(c *Config, source string, src k8sSource, options *Options) error {
+	watchMu.Lock()
+	defer watchMu.Unlock()
+
+	stop := make(chan struct{})
+	if source == k8sConfigSource {
+		if err := watchK8sConfig(src, options.Flags, stop); err != nil {
+			close(stop)
+			return err
+		}
+	} else {
+		watchConfigFile(options.getLocalConfigFile(), options.Flags, stop)
+	}
+
+	settings = c.Viper.AllSettings()
+	stopWatch = stop
+	c.setGlobals()
+	return nil
+}
+
+// watchK8sConfig reloads the configuration whenever the kmux config-map or secret is updated
+func watchK8sConfig(src k8sSource, flags *pflag.FlagSet, stop chan struct{}) error {
+	clientset, err := getK8sClientset(src.kubeconfig)
+	if err != nil {
+		return err
+	}
+
+	// the latest config-map and secret data, guarded by watchMu
+	var configYaml, secretYaml []byte
+	configYaml, err = getConfigFromK8sConfigMap(clientset, src)
+	if err != nil {
+		return err
+	}
+	if src.secret != "" {
+		if secretYaml, err = getConfigFromK8sSecret(clientset, src); err != nil {
+			return err
+		}
+	}
+
+	cmFactory := newK8sInformerFactory(clientset, src.namespace, src.configMap)
+	_, err = cmFactory.Core().V1().ConfigMaps().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(_, obj any) {
+			cm, ok := obj.(*corev1.ConfigMap)
+			if !ok {
+				return
+			}
+			reload(stop, func() (*Config, error) {
+				configYaml = []byte(cm.Data[src.configMapKey])
+				c, err := newConfig(flags)
+				if err != nil {
+					return nil, err
+				}
+				return c, c.readK8sConfig(configYaml, secretYaml)
+			})
+		},
+		DeleteFunc: func(_ any) {
+			log.Warn().Msgf("kmux k8s config-map %s is deleted. Using the last known configuration", src.configMap)
+		},
+	})
+	if err != nil {
+		return err
+	}
+	cmFactory.Start(stop)
+
+	if src.secret != "" {
+		secretFactory := newK8sInformerFactory(clientset, src.namespace, src.secret)
+		_, err = secretFactory.Core().V1().Secrets().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+			UpdateFunc: func(_, obj any) {
+				secret, ok := obj.(*corev1.Secret)
+				if !ok {
+					return
+				}
+				reload(stop, func() (*Config, error) {
+					data, ok := secret.Data[src.secretKey]
+					if !ok {
+						return nil, fmt.Errorf("key %s not found in k8s secret %s", src.secretKey, src.secret)
+					}
+					secretYaml = data
+					c, err := newConfig(flags)
+					if err != nil {
+						return nil, err
+					}
+					return c, c.readK8sConfig(configYaml, secretYaml)
+				})
+			},
+			DeleteFunc: func(_ any) {
+				log.Warn().Msgf("kmux k8s secret %s is deleted. Using the last known configuration", src.secret)
+			},
+		})
+		if err != nil {
+			return err
+		}
+		secretFactory.Start(stop)
+	}
+
+	log.Info().Msgf("Watching k8s config-map %s/%s for changes", src.namespace, src.configMap)
+	return nil
+}
+
+// newK8sInformerFactory returns an informer factory watching the resources with the given name
+func newK8sInformerFactory(clientset kubernetes.Interface, namespace, name string) informers.SharedInformerFactory {
+	return informers.NewSharedInformerFactoryWithOptions(clientset, 0,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.FieldSelector = fmt.Sprintf("metadata.name=%s", name)
+		}))
+}
+
+// watchConfigFile reloads the configuration whenever the local config file is written
+func watchConfigFile(file string, flags *pflag.FlagSet, stop chan struct{}) {
+	// the watcher re-reads the file into its own viper instance, hence
+	// the file is read again into the viper instance of the new configuration
+	watcher := viper.New()
+	watcher.SetConfigFile(file)
+	watcher.OnConfigChange(func(e fsnotify.Event) {
+		reload(stop, func() (*Config, error) {
+			c, err := newConfig(flags)
+			if err != nil {
+				return nil, err
+			}
+			return c, c.loadConfigFromFile(file)
+		})
+	})
+	watcher.WatchConfig()
+	log.Info().Msgf("Watching local kmux config file %s for changes", file)
+}
+
+// reload loads the new configuration into a new viper instance, swaps it in as the
+// active configuration once validated, and notifies the subscribers about the changed sections
+func reload(stop chan struct{}, load func() (*Config, error)) {
+	watchMu.Lock()
+
+	// ignore the events of a watch that is stopped, i.e. after re-initialization
+	if stop != stopWatch {
+		watchMu.Unlock()
+		return
+	}
+
+	c, err := load()
+	if err != nil {
+		watchMu.Unlock()
+		log.Error().Msgf("Failed to reload kmux configuration. %s", err)
+		return
+	}
+
+	currentSettings := c.Viper.AllSettings()
+	changed := changedSections(settings, currentSettings)
+	if len(changed) == 0 {
+		watchMu.Unlock()
+		return
+	}
+
+	// keep the last valid configuration if the new configuration is invalid
+	c.populateConfig()
+	if err = c.validate(); err != nil {
+		watchMu.Unlock()
+		log.Error().Msgf("Ignoring reloaded kmux configuration. %s", err)
+		return
+	}
+	current.Store(c)
+	settings = currentSettings
+
+	log.Info().Msgf("Reloaded kmux configuration. Changed sections - %v", changed)
+	c.printCurrentConfig()
+	watchMu.Unlock()
+
+	notify(ChangeEvent{Sections: changed})
+}
+
+func notify(event ChangeEvent) {
+	subscribersMu.Lock()
+	fns := make([]func(ChangeEvent), 0, len(subscribers))
+	for _, fn := range subscribers {
+		fns = append(fns, fn)
+	}
+	subscribersMu.Unlock()
+
+	for _, fn := range fns {
+		fn(event)
+	}
+}
+
+// changedSections returns the top level sections differing between the two settings
+func changedSections(old, current map[string]any) []string {
+	changed := []string{}
+	for section, value := range current {
+		if !reflect.DeepEqual(old[section], value) {
+			changed = append(changed, section)
+		}
+	}
+	for section := range old {
+		if _, ok := current[section]; !ok {
+			changed = append(changed, section)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
@@ -0,0 +1,40 @@
+package config
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestWatchConfigFailure(t *testing.T) {
+	previous := loadTestConfig(t, `
+kmux:
+  sink:
+    stream: pulsar
+pulsar:
+  servers: ["pulsar:6650"]
+`)
+	previous.setGlobals()
+
+	c := loadTestConfig(t, `
+kmux:
+  sink:
+    stream: kafka
+kafka:
+  brokers: ["kafka:9092"]
+`)
+	src := k8sSource{configMap: "kmux-config", kubeconfig: filepath.Join(t.TempDir(), "kubeconfig")}
+	if err := watchConfig(c, k8sConfigSource, src, &Options{}); err == nil {
+		StopWatching()
+		t.Fatal("watchConfig() succeeded without a kubeconfig")
+	}
+
+	// the configuration is not installed without the watch
+	if Current() != previous || App.Sink.StreamDriver != PulsarDriver {
+		t.Errorf("configuration with sink %s is installed after the watch failed", App.Sink.StreamDriver)
+	}
+	watchMu.Lock()
+	defer watchMu.Unlock()
+	if stopWatch != nil {
+		t.Error("the failed watch is left running")
+	}
+}
@@ -0,0 +1,84 @@
+// Package database implements the database sink and source
+package database
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/ashutosh-the-beast/newknox/config"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Database interface describes the prototypes for kmux's database APIs (MySQL, PostgreSQL, SQLite or MongoDB).
+//
+// The connection handle is returned by SQLDB() for MySQL, PostgreSQL and SQLite,
+// or by MongoDB() for MongoDB.
+type Database interface {
+	// Connect establishes connection with the database
+	Connect() error
+
+	// Disconnect terminates the connection with the database
+	Disconnect()
+}
+
+// Sink is a database used for storing data
+type Sink Database
+
+// Source is a database used for fetching data
+type Source Database
+
+// NewSink returns a database sink driver based on kmux configuration
+func NewSink() (Sink, error) {
+	return NewSinkWithConfig(config.Current())
+}
+
+// NewSinkWithConfig returns a database sink driver based on the given kmux configuration
+func NewSinkWithConfig(cfg *config.Config) (Sink, error) {
+	return newDatabase(cfg, cfg.App.Sink.DatabaseDriver)
+}
+
+// NewSource returns a database source driver based on kmux configuration
+func NewSource() (Source, error) {
+	return NewSourceWithConfig(config.Current())
+}
+
+// NewSourceWithConfig returns a database source driver based on the given kmux configuration
+func NewSourceWithConfig(cfg *config.Config) (Source, error) {
+	return newDatabase(cfg, cfg.App.Source.DatabaseDriver)
+}
+
+// SQLDB returns the connection pool of a MySQL, PostgreSQL or SQLite database.
+// It is only valid after a successful Connect().
+func SQLDB(db Database) (*sql.DB, error) {
+	sd, ok := db.(*SQLDatabase)
+	if !ok {
+		return nil, fmt.Errorf("%T is not a MySQL, PostgreSQL or SQLite database", db)
+	}
+	return sd.DB(), nil
+}
+
+// MongoDB returns a handle for the configured MongoDB database. It is only valid after
+// a successful Connect(), and should not be retained since vault may rotate the credentials.
+func MongoDB(db Database) (*mongo.Database, error) {
+	md, ok := db.(*MongoDatabase)
+	if !ok {
+		return nil, fmt.Errorf("%T is not a MongoDB database", db)
+	}
+	return md.Database(), nil
+}
+
+func newDatabase(cfg *config.Config, driver string) (Database, error) {
+	vaultOpt := vaultOptions{driver: cfg.App.Vault, options: cfg.Vault}
+
+	switch driver {
+	case config.MySQLDriver, config.PostgreSQLDriver, config.SQLiteDriver:
+		db := NewSQLDatabase(driver, cfg.Database)
+		db.vaultOpt = vaultOpt
+		return db, nil
+	case config.MongoDBDriver:
+		db := NewMongoDatabase(cfg.Database)
+		db.vaultOpt = vaultOpt
+		return db, nil
+	}
+	return nil, fmt.Errorf("database driver %s not supported", driver)
+}
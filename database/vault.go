@@ -0,0 +1,74 @@
+package database
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ashutosh-the-beast/newknox/config"
+	"github.com/ashutosh-the-beast/newknox/vault"
+)
+
+// vaultOptions contains the vault driver and configuration used to resolve the database credentials
+type vaultOptions struct {
+	driver  string
+	options config.HashiVaultConfig
+}
+
+// credentials are the database username and password read from vault
+type credentials struct {
+	username string
+	password string
+}
+
+// resolveCredentials reads the database username and password from the vault secret
+// referenced by `database.vault`. The returned vault client keeps renewing its token
+// and the secret lease until it is closed. When the lease can not be renewed any more
+// the secret is read again and the new credentials, or the failure to read them, are
+// passed to onChange.
+func resolveCredentials(opt *config.DatabaseVault, vaultOpt vaultOptions, onChange func(credentials, error)) (*vault.Client, credentials, error) {
+	if opt == nil {
+		return nil, credentials{}, nil
+	}
+	if vaultOpt.driver != config.HashiVaultDriver {
+		return nil, credentials{}, fmt.Errorf("vault driver %q not supported", vaultOpt.driver)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	defer cancel()
+
+	client := vault.NewClient(vaultOpt.options)
+	if err := client.Login(ctx); err != nil {
+		return nil, credentials{}, err
+	}
+
+	secret, err := client.WatchSecret(ctx, opt.SecretPath, func(secret *vault.Secret, err error) {
+		if err != nil {
+			onChange(credentials{}, err)
+			return
+		}
+		onChange(secretCredentials(secret, opt))
+	})
+	if err != nil {
+		client.Close()
+		return nil, credentials{}, err
+	}
+
+	creds, err := secretCredentials(secret, opt)
+	if err != nil {
+		client.Close()
+		return nil, credentials{}, err
+	}
+	return client, creds, nil
+}
+
+func secretCredentials(secret *vault.Secret, opt *config.DatabaseVault) (credentials, error) {
+	username, ok := secret.Data[opt.Key.Username].(string)
+	if !ok {
+		return credentials{}, fmt.Errorf("key %s not found in vault secret %s", opt.Key.Username, opt.SecretPath)
+	}
+	password, ok := secret.Data[opt.Key.Password].(string)
+	if !ok {
+		return credentials{}, fmt.Errorf("key %s not found in vault secret %s", opt.Key.Password, opt.SecretPath)
+	}
+	return credentials{username: username, password: password}, nil
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"time"
 
 	kmux "github.com/ashutosh-the-beast/newknox"
 	"github.com/ashutosh-the-beast/newknox/config"
@@ -43,6 +44,9 @@ func main() {
 	// Sink.Flush() API example
 	flush(ss)
 
+	// AsyncSink.FlushAsync() API example
+	flushAsync(ss)
+
 	// Sink.ProcessChannel() API example
 	processChannel(ss)
 
@@ -67,6 +71,33 @@ func flush(ss stream.Sink) {
 	}
 }
 
+func flushAsync(ss stream.Sink) {
+
+	// Publish []books to Pulsar asynchronously
+
+	as, ok := ss.(stream.AsyncSink)
+	if !ok {
+		return
+	}
+
+	for _, book := range books {
+		book := book // prevent memory aliasing issue since address of the for loop var is used in next stmt
+		bytes, err := json.Marshal(&book)
+		exitOnError(err)
+
+		// AsyncSink.FlushAsync()
+		as.FlushAsync(bytes, func(err error) {
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err.Error())
+			}
+		})
+	}
+
+	// Wait for the queued messages to be sent
+	err := as.Wait()
+	exitOnError(err)
+}
+
 func processChannel(ss stream.Sink) {
 
 	// Publish []books to Pulsar asynchronously
@@ -80,13 +111,21 @@ func processChannel(ss stream.Sink) {
 	ctx, sinkCtxCancel := context.WithCancel(context.Background())
 
 	// Sink.ProcessChannel()
-	go ss.ProcessChannel(ctx, channel, func(data interface{}) ([]byte, error) {
-		book := data.(book)
-		return json.Marshal(&book)
-	})
-
-	// When the sink process job is no longer needed, cancel the context
+	done := make(chan stream.ProcessSummary)
+	go func() {
+		done <- ss.ProcessChannel(ctx, channel, func(data interface{}) ([]byte, error) {
+			book := data.(book)
+			return json.Marshal(&book)
+		}, stream.WithDrain(5*time.Second))
+	}()
+
+	// When the sink process job is no longer needed, cancel the context.
+	// The messages already queued in the channel are still sent, since
+	// the channel is processed with `stream.WithDrain()`.
 	sinkCtxCancel()
+
+	summary := <-done
+	fmt.Printf("Sent %d, failed %d, abandoned %d books\n", summary.Sent, summary.Failed, summary.Abandoned)
 }
 
 func exitOnError(err error) {
@@ -0,0 +1,59 @@
+// Package main
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+
+	kmux "github.com/ashutosh-the-beast/newknox"
+	"github.com/ashutosh-the-beast/newknox/config"
+	"github.com/ashutosh-the-beast/newknox/stream"
+)
+
+type book struct {
+	Book   string `json:"book"`
+	Author string `json:"author"`
+}
+
+func main() {
+	// Init
+	err := kmux.Init(&config.Options{
+		LocalConfigFile: "kmux-config.yaml",
+	})
+	exitOnError(err)
+
+	// Create a stream source
+	src, err := kmux.NewStreamSource("book")
+	exitOnError(err)
+
+	// Connect with the source
+	err = src.Connect()
+	exitOnError(err)
+
+	// Stop receiving messages on interrupt
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	// Source.Subscribe() API example
+	src.Subscribe(ctx, func(msg *stream.SourceMessage) error {
+		var b book
+		if err := json.Unmarshal(msg.Payload, &b); err != nil {
+			return err
+		}
+		fmt.Printf("Received book %s by %s\n", b.Book, b.Author)
+		return nil
+	})
+
+	// Disconnect from the source
+	src.Disconnect()
+}
+
+func exitOnError(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,62 @@
+// Package main
+package main
+
+import (
+	"fmt"
+	"os"
+
+	kmux "github.com/ashutosh-the-beast/newknox"
+	"github.com/ashutosh-the-beast/newknox/config"
+	"github.com/ashutosh-the-beast/newknox/database"
+)
+
+type book struct {
+	Book   string
+	Author string
+}
+
+var books = []book{
+	{"Thirukkural", "Thiruvalluvar"},
+	{"Silappathikaram", "Ilango Adigal"},
+	{"Manimekalai", "Sithalai Sattanar"},
+	{"Sivaka Sintamani", "Thirutakkathevar"},
+	{"Kundalakesi", "Nathakuthanaar"},
+}
+
+func main() {
+	// Init
+	err := kmux.Init(&config.Options{
+		LocalConfigFile: "kmux-config.yaml",
+	})
+	exitOnError(err)
+
+	// Create a database sink
+	ds, err := kmux.NewDatabaseSink()
+	exitOnError(err)
+
+	// Connect with the database
+	err = ds.Connect()
+	exitOnError(err)
+
+	// Use the connection pool of the database
+	db, err := database.SQLDB(ds)
+	exitOnError(err)
+
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS books (book TEXT, author TEXT)")
+	exitOnError(err)
+
+	for _, b := range books {
+		_, err = db.Exec("INSERT INTO books (book, author) VALUES (?, ?)", b.Book, b.Author)
+		exitOnError(err)
+	}
+
+	// Disconnect from the database
+	ds.Disconnect()
+}
+
+func exitOnError(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+}
@@ -3,16 +3,168 @@ package kmux
 
 import (
 	"github.com/ashutosh-the-beast/newknox/config"
+	"github.com/ashutosh-the-beast/newknox/database"
+	"github.com/ashutosh-the-beast/newknox/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/ashutosh-the-beast/newknox/stream"
 )
 
+// Client builds sinks and sources based on its own kmux configuration,
+// hence one process can use several kmux configurations, i.e. to talk to two pulsar clusters
+type Client struct {
+	// config is nil for the default client, which uses the configuration loaded by Init()
+	config *config.Config
+}
+
+// defaultClient is used by the package level functions
+var defaultClient = &Client{}
+
 // Init initializes kmux configuration settings
 func Init(options *config.Options) error {
-	return config.Init(options)
+	if err := config.Init(options); err != nil {
+		return err
+	}
+
+	cfg := config.Current()
+	if cfg.App.Tracing.Enable {
+		var tp trace.TracerProvider
+		var propagator propagation.TextMapPropagator
+		if options != nil {
+			tp, propagator = options.TracerProvider, options.Propagator
+		}
+		stream.EnableTracing(tp, propagator)
+	}
+
+	if cfg.App.Metrics.Enable {
+		var reg prometheus.Registerer
+		if options != nil {
+			reg = options.MetricsRegisterer
+		}
+		return metrics.Register(reg, cfg.App.Metrics.Namespace)
+	}
+	return nil
+}
+
+// New returns a client with the kmux configuration loaded with the given options.
+// The package level configuration is not modified and Options.WatchConfig is not supported.
+// The metrics and the tracing of the client use its own Options.MetricsRegisterer and
+// Options.TracerProvider, without affecting the other clients.
+func New(options *config.Options) (*Client, error) {
+	cfg, err := config.New(options)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.App.Tracing.Enable {
+		cfg.TracerProvider = otel.GetTracerProvider()
+		if options != nil && options.TracerProvider != nil {
+			cfg.TracerProvider = options.TracerProvider
+		}
+		if options != nil {
+			cfg.Propagator = options.Propagator
+		}
+	}
+
+	if cfg.App.Metrics.Enable {
+		var reg prometheus.Registerer
+		if options != nil {
+			reg = options.MetricsRegisterer
+		}
+		if cfg.Metrics, err = metrics.New(reg, cfg.App.Metrics.Namespace); err != nil {
+			return nil, err
+		}
+	}
+	return &Client{config: cfg}, nil
+}
+
+// Config returns the kmux configuration of the client
+func (c *Client) Config() *config.Config {
+	if c.config == nil {
+		return config.Current()
+	}
+	return c.config
+}
+
+// NewStreamSink returns a stream sink based on the client configuration
+func (c *Client) NewStreamSink(topic string) (stream.Sink, error) {
+	return stream.NewSinkWithConfig(c.Config(), topic)
+}
+
+// NewStreamCodecSink returns a stream sink based on the client configuration
+// which encodes the values with the codec configured for the topic
+func (c *Client) NewStreamCodecSink(topic string) (*stream.CodecSink, error) {
+	return stream.NewCodecSinkWithConfig(c.Config(), topic)
+}
+
+// NewStreamSource returns a stream source based on the client configuration
+func (c *Client) NewStreamSource(topic string) (stream.Source, error) {
+	return stream.NewSourceWithConfig(c.Config(), topic)
+}
+
+// NewDatabaseSink returns a database sink based on the client configuration
+func (c *Client) NewDatabaseSink() (database.Sink, error) {
+	return database.NewSinkWithConfig(c.Config())
+}
+
+// NewDatabaseSource returns a database source based on the client configuration
+func (c *Client) NewDatabaseSource() (database.Source, error) {
+	return database.NewSourceWithConfig(c.Config())
 }
 
 // NewStreamSink returns a stream sink based on kmux configuration
 func NewStreamSink(topic string) (stream.Sink, error) {
-	return stream.NewSink(topic)
+	return defaultClient.NewStreamSink(topic)
+}
+
+// NewReloadingStreamSink returns a stream sink based on kmux configuration
+// which reconnects whenever the configuration of the sink driver changes
+func NewReloadingStreamSink(topic string) (*stream.ReloadingSink, error) {
+	return stream.NewReloadingSink(topic)
+}
+
+// NewStreamCodecSink returns a stream sink based on kmux configuration
+// which encodes the values with the codec configured for the topic
+func NewStreamCodecSink(topic string) (*stream.CodecSink, error) {
+	return defaultClient.NewStreamCodecSink(topic)
+}
+
+// NewTypedStreamSink returns a stream sink based on kmux configuration
+// which flushes values of type T encoded with the codec configured for the topic
+func NewTypedStreamSink[T any](topic string) (*stream.TypedSink[T], error) {
+	return NewClientTypedStreamSink[T](defaultClient, topic)
+}
+
+// NewClientTypedStreamSink returns a stream sink based on the client configuration
+// which flushes values of type T encoded with the codec configured for the topic
+func NewClientTypedStreamSink[T any](c *Client, topic string) (*stream.TypedSink[T], error) {
+	cfg := c.Config()
+	codec, err := stream.CodecForTopicWithConfig(cfg.Codecs, topic)
+	if err != nil {
+		return nil, err
+	}
+
+	sink, err := stream.NewSinkWithConfig(cfg, topic)
+	if err != nil {
+		return nil, err
+	}
+	return stream.NewTypedSinkWithCodec[T](sink, codec), nil
+}
+
+// NewStreamSource returns a stream source based on kmux configuration
+func NewStreamSource(topic string) (stream.Source, error) {
+	return defaultClient.NewStreamSource(topic)
+}
+
+// NewDatabaseSink returns a database sink based on kmux configuration
+func NewDatabaseSink() (database.Sink, error) {
+	return defaultClient.NewDatabaseSink()
+}
+
+// NewDatabaseSource returns a database source based on kmux configuration
+func NewDatabaseSource() (database.Source, error) {
+	return defaultClient.NewDatabaseSource()
 }
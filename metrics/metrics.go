@@ -0,0 +1,228 @@
+// Package metrics implements the prometheus metrics exported by kmux sinks and sources
+package metrics
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// DefaultNamespace is the namespace of the metrics when none is configured
+	DefaultNamespace = "kmux"
+)
+
+// Metrics holds the kmux collectors registered on a registerer. The methods of
+// a nil Metrics do not record anything.
+type Metrics struct {
+	messagesSent     *prometheus.CounterVec
+	bytesSent        *prometheus.CounterVec
+	sendFailures     *prometheus.CounterVec
+	flushLatency     *prometheus.HistogramVec
+	messagesReceived *prometheus.CounterVec
+	bytesReceived    *prometheus.CounterVec
+	receiveFailures  *prometheus.CounterVec
+	reconnects       *prometheus.CounterVec
+	queueDepth       *prometheus.GaugeVec
+}
+
+// defaultMetrics holds the collectors registered by Register(), used by the package level functions
+var defaultMetrics atomic.Pointer[Metrics]
+
+// Register creates the kmux metrics, registers them on the given registerer and
+// records the metrics of the sinks and sources not having their own Metrics.
+func Register(reg prometheus.Registerer, namespace string) error {
+	m, err := New(reg, namespace)
+	if err != nil {
+		return err
+	}
+	defaultMetrics.Store(m)
+	return nil
+}
+
+// Default returns the metrics registered by Register(), or nil
+func Default() *Metrics {
+	return defaultMetrics.Load()
+}
+
+// New creates the kmux metrics and registers them on the given registerer,
+// prometheus.DefaultRegisterer when nil. The namespace defaults to `kmux`.
+func New(reg prometheus.Registerer, namespace string) (*Metrics, error) {
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+	if namespace == "" {
+		namespace = DefaultNamespace
+	}
+
+	labels := []string{"driver", "topic"}
+	c := &Metrics{
+		messagesSent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "sink",
+			Name:      "messages_sent_total",
+			Help:      "Number of messages sent through the sink.",
+		}, labels),
+		bytesSent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "sink",
+			Name:      "bytes_sent_total",
+			Help:      "Number of payload bytes sent through the sink.",
+		}, labels),
+		sendFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "sink",
+			Name:      "send_failures_total",
+			Help:      "Number of messages failed to be sent through the sink.",
+		}, labels),
+		flushLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "sink",
+			Name:      "flush_duration_seconds",
+			Help:      "Time taken to send a message through the sink.",
+			Buckets:   prometheus.DefBuckets,
+		}, labels),
+		messagesReceived: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "source",
+			Name:      "messages_received_total",
+			Help:      "Number of messages received through the source.",
+		}, labels),
+		bytesReceived: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "source",
+			Name:      "bytes_received_total",
+			Help:      "Number of payload bytes received through the source.",
+		}, labels),
+		receiveFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "source",
+			Name:      "receive_failures_total",
+			Help:      "Number of failures while receiving messages through the source.",
+		}, labels),
+		reconnects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "reconnects_total",
+			Help:      "Number of attempts to re-establish a broken connection.",
+		}, []string{"driver", "server"}),
+		queueDepth: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "sink",
+			Name:      "process_channel_queue_depth",
+			Help:      "Number of messages waiting in the channel processed by Sink.ProcessChannel().",
+		}, labels),
+	}
+
+	var err error
+	if c.messagesSent, err = register(reg, c.messagesSent); err != nil {
+		return nil, err
+	}
+	if c.bytesSent, err = register(reg, c.bytesSent); err != nil {
+		return nil, err
+	}
+	if c.sendFailures, err = register(reg, c.sendFailures); err != nil {
+		return nil, err
+	}
+	if c.flushLatency, err = register(reg, c.flushLatency); err != nil {
+		return nil, err
+	}
+	if c.messagesReceived, err = register(reg, c.messagesReceived); err != nil {
+		return nil, err
+	}
+	if c.bytesReceived, err = register(reg, c.bytesReceived); err != nil {
+		return nil, err
+	}
+	if c.receiveFailures, err = register(reg, c.receiveFailures); err != nil {
+		return nil, err
+	}
+	if c.reconnects, err = register(reg, c.reconnects); err != nil {
+		return nil, err
+	}
+	if c.queueDepth, err = register(reg, c.queueDepth); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// register registers the collector on reg. If an identical collector is already
+// registered (e.g. kmux is initialized more than once), the existing one is reused.
+func register[T prometheus.Collector](reg prometheus.Registerer, collector T) (T, error) {
+	err := reg.Register(collector)
+	if err == nil {
+		return collector, nil
+	}
+
+	var are prometheus.AlreadyRegisteredError
+	if errors.As(err, &are) {
+		if existing, ok := are.ExistingCollector.(T); ok {
+			return existing, nil
+		}
+	}
+	return collector, err
+}
+
+// ObserveFlush records the outcome of sending a message through a sink
+func (m *Metrics) ObserveFlush(driver, topic string, size int, duration time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	if err != nil {
+		m.sendFailures.WithLabelValues(driver, topic).Inc()
+		return
+	}
+	m.messagesSent.WithLabelValues(driver, topic).Inc()
+	m.bytesSent.WithLabelValues(driver, topic).Add(float64(size))
+	m.flushLatency.WithLabelValues(driver, topic).Observe(duration.Seconds())
+}
+
+// ObserveReceive records the outcome of receiving a message through a source
+func (m *Metrics) ObserveReceive(driver, topic string, size int, err error) {
+	if m == nil {
+		return
+	}
+	if err != nil {
+		m.receiveFailures.WithLabelValues(driver, topic).Inc()
+		return
+	}
+	m.messagesReceived.WithLabelValues(driver, topic).Inc()
+	m.bytesReceived.WithLabelValues(driver, topic).Add(float64(size))
+}
+
+// IncReconnect records an attempt to re-establish a broken connection with the server
+func (m *Metrics) IncReconnect(driver, server string) {
+	if m == nil {
+		return
+	}
+	m.reconnects.WithLabelValues(driver, server).Inc()
+}
+
+// SetQueueDepth records the number of messages waiting in a sink channel
+func (m *Metrics) SetQueueDepth(driver, topic string, depth int) {
+	if m == nil {
+		return
+	}
+	m.queueDepth.WithLabelValues(driver, topic).Set(float64(depth))
+}
+
+// ObserveFlush records the outcome of sending a message through a sink in the default metrics
+func ObserveFlush(driver, topic string, size int, duration time.Duration, err error) {
+	Default().ObserveFlush(driver, topic, size, duration, err)
+}
+
+// ObserveReceive records the outcome of receiving a message through a source in the default metrics
+func ObserveReceive(driver, topic string, size int, err error) {
+	Default().ObserveReceive(driver, topic, size, err)
+}
+
+// IncReconnect records an attempt to re-establish a broken connection in the default metrics
+func IncReconnect(driver, server string) {
+	Default().IncReconnect(driver, server)
+}
+
+// SetQueueDepth records the number of messages waiting in a sink channel in the default metrics
+func SetQueueDepth(driver, topic string, depth int) {
+	Default().SetQueueDepth(driver, topic, depth)
+}
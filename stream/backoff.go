@@ -0,0 +1,53 @@
+package stream
+
+import (
+	"math/rand"
+	"time"
+)
+
+// backoff computes exponentially growing delays with random jitter
+type backoff struct {
+	initial    time.Duration
+	max        time.Duration
+	multiplier float64
+	jitter     float64
+
+	current time.Duration
+}
+
+func newBackoff(initial, max time.Duration, multiplier, jitter float64) *backoff {
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	return &backoff{
+		initial:    initial,
+		max:        max,
+		multiplier: multiplier,
+		jitter:     jitter,
+	}
+}
+
+// reset restarts the delays from the initial delay
+func (b *backoff) reset() {
+	b.current = 0
+}
+
+// next returns the delay to wait before the next attempt
+func (b *backoff) next() time.Duration {
+	if b.current == 0 {
+		b.current = b.initial
+	} else {
+		b.current = time.Duration(float64(b.current) * b.multiplier)
+	}
+	if b.max > 0 && b.current > b.max {
+		b.current = b.max
+	}
+
+	delay := b.current
+	if b.jitter > 0 {
+		// randomize the delay within [delay - jitter*delay, delay + jitter*delay]
+		delta := b.jitter * float64(delay)
+		delay = time.Duration(float64(delay) - delta + rand.Float64()*2*delta) // #nosec G404
+	}
+	return delay
+}
@@ -0,0 +1,193 @@
+package stream
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ashutosh-the-beast/newknox/config"
+	"github.com/linkedin/goavro/v2"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// JSONCodec specifies that the payload is encoded as JSON
+	JSONCodec = "json"
+
+	// ProtobufCodec specifies that the payload is encoded as Protocol Buffers
+	ProtobufCodec = "protobuf"
+
+	// AvroCodec specifies that the payload is encoded as Avro binary
+	AvroCodec = "avro"
+
+	// MsgPackCodec specifies that the payload is encoded as MessagePack
+	MsgPackCodec = "msgpack"
+)
+
+// Codec describes the prototypes for encoding values into message payloads and back
+type Codec interface {
+	// Name returns the name of the codec, i.e. `json`
+	Name() string
+
+	// Encode encodes the value into a message payload
+	Encode(v any) ([]byte, error)
+
+	// Decode decodes the message payload into the value pointed by v
+	Decode(data []byte, v any) error
+}
+
+// NewCodec returns the codec with the given name. schema is the Avro schema
+// and is required by the avro codec only.
+func NewCodec(name, schema string) (Codec, error) {
+	switch strings.ToLower(name) {
+	case "", JSONCodec:
+		return jsonCodec{}, nil
+	case ProtobufCodec:
+		return protobufCodec{}, nil
+	case AvroCodec:
+		return NewAvroCodec(schema)
+	case MsgPackCodec:
+		return msgpackCodec{}, nil
+	}
+	return nil, fmt.Errorf("codec %s not supported", name)
+}
+
+// CodecForTopic returns the codec configured for the topic, or the default codec
+// if the topic has no codec configured
+func CodecForTopic(topic string) (Codec, error) {
+	return CodecForTopicWithConfig(config.Current().Codecs, topic)
+}
+
+// CodecForTopicWithConfig returns the codec configured for the topic in the given configuration
+func CodecForTopicWithConfig(cfg config.CodecsConfig, topic string) (Codec, error) {
+	opt, ok := cfg.Topics[strings.ToLower(topic)]
+	if !ok {
+		return NewCodec(cfg.Default, "")
+	}
+
+	schema := opt.Schema
+	if opt.SchemaFile != "" {
+		data, err := os.ReadFile(opt.SchemaFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read schema file of topic %s. %s", topic, err)
+		}
+		schema = string(data)
+	}
+	return NewCodec(opt.Codec, schema)
+}
+
+// EncodeWith returns a SinkProcessFunc encoding the data received from the sink channel with the codec
+func EncodeWith(codec Codec) SinkProcessFunc {
+	return codec.Encode
+}
+
+// jsonCodec implements `stream.Codec` using encoding/json
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return JSONCodec
+}
+
+func (jsonCodec) Encode(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Decode(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+// protobufCodec implements `stream.Codec` for values implementing `proto.Message`
+type protobufCodec struct{}
+
+func (protobufCodec) Name() string {
+	return ProtobufCodec
+}
+
+func (protobufCodec) Encode(v any) ([]byte, error) {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("protobuf codec: %T does not implement proto.Message", v)
+	}
+	return proto.Marshal(msg)
+}
+
+func (protobufCodec) Decode(data []byte, v any) error {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("protobuf codec: %T does not implement proto.Message", v)
+	}
+	return proto.Unmarshal(data, msg)
+}
+
+// msgpackCodec implements `stream.Codec` using MessagePack
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string {
+	return MsgPackCodec
+}
+
+func (msgpackCodec) Encode(v any) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (msgpackCodec) Decode(data []byte, v any) error {
+	return msgpack.Unmarshal(data, v)
+}
+
+// avroCodec implements `stream.Codec` encoding values as Avro binary
+type avroCodec struct {
+	codec *goavro.Codec
+}
+
+// NewAvroCodec returns a codec encoding values as Avro binary with the given schema.
+//
+// Values other than map[string]any are converted through their JSON representation,
+// hence struct fields should be tagged with the names of the schema fields.
+func NewAvroCodec(schema string) (Codec, error) {
+	if schema == "" {
+		return nil, fmt.Errorf("avro codec requires a schema")
+	}
+	codec, err := goavro.NewCodec(schema)
+	if err != nil {
+		return nil, fmt.Errorf("invalid avro schema. %s", err)
+	}
+	return &avroCodec{codec: codec}, nil
+}
+
+func (c *avroCodec) Name() string {
+	return AvroCodec
+}
+
+// Schema returns the canonical form of the Avro schema
+func (c *avroCodec) Schema() string {
+	return c.codec.CanonicalSchema()
+}
+
+func (c *avroCodec) Encode(v any) ([]byte, error) {
+	var native any = v
+	if _, ok := v.(map[string]any); !ok {
+		text, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		native, _, err = c.codec.NativeFromTextual(text)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c.codec.BinaryFromNative(nil, native)
+}
+
+func (c *avroCodec) Decode(data []byte, v any) error {
+	native, _, err := c.codec.NativeFromBinary(data)
+	if err != nil {
+		return err
+	}
+	text, err := c.codec.TextualFromNative(nil, native)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(text, v)
+}
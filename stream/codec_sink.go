@@ -0,0 +1,63 @@
+package stream
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ashutosh-the-beast/newknox/config"
+)
+
+// CodecSink wraps a stream sink to accept values encoded with a codec
+type CodecSink struct {
+	Sink
+	codec Codec
+}
+
+// NewCodecSink returns a stream sink based on kmux configuration which encodes
+// the values with the codec configured for the topic
+func NewCodecSink(topic string) (*CodecSink, error) {
+	return NewCodecSinkWithConfig(config.Current(), topic)
+}
+
+// NewCodecSinkWithConfig returns a stream sink based on the given kmux configuration
+// which encodes the values with the codec configured for the topic
+func NewCodecSinkWithConfig(cfg *config.Config, topic string) (*CodecSink, error) {
+	codec, err := CodecForTopicWithConfig(cfg.Codecs, topic)
+	if err != nil {
+		return nil, err
+	}
+
+	sink, err := NewSinkWithConfig(cfg, topic)
+	if err != nil {
+		return nil, err
+	}
+	return WithCodec(sink, codec), nil
+}
+
+// WithCodec wraps the sink to encode values with the given codec
+func WithCodec(sink Sink, codec Codec) *CodecSink {
+	return &CodecSink{Sink: sink, codec: codec}
+}
+
+// Codec returns the codec used to encode the values
+func (cs *CodecSink) Codec() Codec {
+	return cs.codec
+}
+
+// FlushValue encodes the value with the codec and flushes it through the sink
+func (cs *CodecSink) FlushValue(v any) error {
+	data, err := cs.codec.Encode(v)
+	if err != nil {
+		return fmt.Errorf("%s: Failed to encode message with %s codec. %s", sinkName(cs.Sink), cs.codec.Name(), err)
+	}
+	return cs.Flush(data)
+}
+
+// ProcessChannel implements `Sink.ProcessChannel()`. The data received from the
+// channel is encoded with the codec when processFn is nil.
+func (cs *CodecSink) ProcessChannel(ctx context.Context, events chan any, processFn SinkProcessFunc, opts ...ProcessOption) ProcessSummary {
+	if processFn == nil {
+		processFn = EncodeWith(cs.codec)
+	}
+	return cs.Sink.ProcessChannel(ctx, events, processFn, opts...)
+}
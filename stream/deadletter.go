@@ -0,0 +1,93 @@
+package stream
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// DeadLetter is a destination for the messages which could not be flushed through a sink
+type DeadLetter interface {
+	// Send stores the message along with the error which caused it to be dead lettered
+	Send(*Message, error) error
+}
+
+// sinkDeadLetter implements `DeadLetter` by flushing the messages through another sink
+type sinkDeadLetter struct {
+	sink Sink
+}
+
+// NewDeadLetterSink returns a dead letter destination which flushes the messages
+// through the given sink, i.e. a sink created for a dead letter topic.
+// The sink should be connected before processing the channel.
+func NewDeadLetterSink(sink Sink) DeadLetter {
+	return &sinkDeadLetter{sink: sink}
+}
+
+func (dl *sinkDeadLetter) Send(msg *Message, _ error) error {
+	return dl.sink.FlushMessage(msg)
+}
+
+// DeadLetterFile implements `DeadLetter` by appending the messages to a local file as JSON lines
+type DeadLetterFile struct {
+	mu   sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+// deadLetterRecord is a single line of the dead letter file
+type deadLetterRecord struct {
+	Time       time.Time         `json:"time"`
+	Error      string            `json:"error"`
+	Key        string            `json:"key,omitempty"`
+	Properties map[string]string `json:"properties,omitempty"`
+	EventTime  *time.Time        `json:"eventTime,omitempty"`
+	Payload    []byte            `json:"payload"`
+}
+
+// NewDeadLetterFile returns a dead letter destination which appends the messages
+// to the given file. The payload is stored base64 encoded.
+func NewDeadLetterFile(path string) (*DeadLetterFile, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("DeadLetterFile: Failed to open %s. %s", path, err)
+	}
+	return &DeadLetterFile{
+		file: file,
+		enc:  json.NewEncoder(file),
+	}, nil
+}
+
+// Send implements `DeadLetter.Send()`
+func (dl *DeadLetterFile) Send(msg *Message, cause error) error {
+	record := deadLetterRecord{
+		Time:       time.Now(),
+		Key:        msg.Key,
+		Properties: msg.Properties,
+		Payload:    msg.Payload,
+	}
+	if cause != nil {
+		record.Error = cause.Error()
+	}
+	if !msg.EventTime.IsZero() {
+		record.EventTime = &msg.EventTime
+	}
+
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+
+	if err := dl.enc.Encode(&record); err != nil {
+		return fmt.Errorf("DeadLetterFile: Failed to write message to %s. %s", dl.file.Name(), err)
+	}
+	return nil
+}
+
+// Close closes the dead letter file
+func (dl *DeadLetterFile) Close() error {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+
+	return dl.file.Close()
+}
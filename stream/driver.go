@@ -0,0 +1,89 @@
+package stream
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/ashutosh-the-beast/newknox/config"
+	"github.com/rs/xid"
+	"github.com/spf13/viper"
+)
+
+// SinkDriverFactory returns a sink of the driver for the topic. The factory receives the
+// kmux configuration and the configuration section of the driver, i.e. `nats` for
+// the `nats` driver, which is empty when the section is not configured. The keys of the
+// section are overridden by the environment variables and the flags, see config.Config.Sub().
+type SinkDriverFactory func(cfg *config.Config, section *viper.Viper, topic string) (Sink, error)
+
+var (
+	sinkDriversMu sync.RWMutex
+	sinkDrivers   = map[string]SinkDriverFactory{}
+)
+
+func init() {
+	RegisterSinkDriver(config.PulsarDriver, func(cfg *config.Config, _ *viper.Viper, topic string) (Sink, error) {
+		publisher := fmt.Sprintf("kmux-pub-%s", xid.New().String())
+		ps := NewPulsarSinkWithConfig(cfg.Pulsar, topic, publisher)
+		ps.telemetry = telemetryFor(cfg)
+		return ps, nil
+	})
+	RegisterSinkDriver(config.KafkaDriver, func(cfg *config.Config, _ *viper.Viper, topic string) (Sink, error) {
+		clientID := fmt.Sprintf("kmux-pub-%s", xid.New().String())
+		ks := NewKafkaSinkWithConfig(cfg.Kafka, topic, clientID)
+		ks.telemetry = telemetryFor(cfg)
+		return ks, nil
+	})
+	RegisterSinkDriver(config.KnoxGatewayDriver, func(cfg *config.Config, _ *viper.Viper, topic string) (Sink, error) {
+		kg := NewKnoxGatewaySinkWithConfig(cfg.KnoxGateway, topic)
+		kg.telemetry = telemetryFor(cfg)
+		return kg, nil
+	})
+}
+
+// RegisterSinkDriver makes a stream sink driver available by the name, which can then be
+// selected with `kmux.sink.stream`. The configuration section named after the driver is
+// not validated by kmux. If RegisterSinkDriver is called twice with the same name or
+// if the factory is nil, it panics.
+func RegisterSinkDriver(name string, factory SinkDriverFactory) {
+	sinkDriversMu.Lock()
+	defer sinkDriversMu.Unlock()
+
+	if factory == nil {
+		panic("stream: RegisterSinkDriver factory is nil")
+	}
+	if _, dup := sinkDrivers[name]; dup {
+		panic("stream: RegisterSinkDriver called twice for driver " + name)
+	}
+	sinkDrivers[name] = factory
+	config.RegisterSinkStreamDriver(name)
+}
+
+// SinkDrivers returns a sorted list of the names of the registered sink drivers
+func SinkDrivers() []string {
+	sinkDriversMu.RLock()
+	defer sinkDriversMu.RUnlock()
+
+	names := make([]string, 0, len(sinkDrivers))
+	for name := range sinkDrivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newSinkFromDriver returns a sink of the registered driver for the topic
+func newSinkFromDriver(cfg *config.Config, driver, topic string) (Sink, error) {
+	sinkDriversMu.RLock()
+	factory, ok := sinkDrivers[driver]
+	sinkDriversMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("sink driver %s not supported", driver)
+	}
+
+	section, err := cfg.Sub(driver)
+	if err != nil {
+		return nil, fmt.Errorf("sink driver %s: Failed to read configuration section. %s", driver, err)
+	}
+	return factory(cfg, section, topic)
+}
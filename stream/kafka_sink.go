@@ -0,0 +1,207 @@
+package stream
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/ashutosh-the-beast/newknox/config"
+	"github.com/rs/zerolog/log"
+	"github.com/xdg-go/scram"
+)
+
+// KafkaSink implements `stream.Sink` interface for Apache Kafka
+type KafkaSink struct {
+	producer sarama.SyncProducer
+	options  config.KafkaConfig
+	topic    string
+	clientID string
+
+	telemetry *telemetry
+}
+
+// NewKafkaSink returns a stream sink for Apache Kafka
+func NewKafkaSink(topic, clientID string) *KafkaSink {
+	return NewKafkaSinkWithConfig(config.Current().Kafka, topic, clientID)
+}
+
+// NewKafkaSinkWithConfig returns a stream sink for Apache Kafka with the given configuration
+func NewKafkaSinkWithConfig(cfg config.KafkaConfig, topic, clientID string) *KafkaSink {
+	return &KafkaSink{
+		options:  cfg,
+		topic:    cfg.TopicPrefix + topic,
+		clientID: clientID,
+	}
+}
+
+// Connect implements `Sink.Connect()`
+func (ks *KafkaSink) Connect() error {
+	conf, err := newSaramaConfig(ks.options, ks.clientID)
+	if err != nil {
+		return fmt.Errorf("KafkaSink: Invalid kafka configuration. %s", err)
+	}
+
+	ks.producer, err = sarama.NewSyncProducer(ks.options.Brokers, conf)
+	if err != nil {
+		return fmt.Errorf("KafkaSink: Failed to create a producer for topic %s. %s", ks.topic, err)
+	}
+
+	return nil
+}
+
+// Flush implements `sink.Flush()`
+func (ks *KafkaSink) Flush(data []byte) error {
+	return ks.FlushMessage(&Message{Payload: data})
+}
+
+// FlushMessage implements `sink.FlushMessage()`
+func (ks *KafkaSink) FlushMessage(msg *Message) error {
+	msg, span := ks.telemetry.startProducerSpan(config.KafkaDriver, ks.topic, msg)
+	start := time.Now()
+	_, _, err := ks.producer.SendMessage(ks.newKafkaMessage(msg))
+	ks.telemetry.getMetrics().ObserveFlush(config.KafkaDriver, ks.topic, len(msg.Payload), time.Since(start), err)
+	endSpan(span, err)
+	if err != nil {
+		return fmt.Errorf(
+			"KafkaSink: Failed to send message. Topic - %s, Message - %s, Error - %s",
+			ks.topic, string(msg.Payload), err)
+	}
+
+	log.Info().Msgf("KafkaSink: Topic - %s | Message - %s", ks.topic, truncatePayload(msg.Payload))
+	return nil
+}
+
+// ProcessChannel implements `Sink.ProcessChannel()`
+func (ks *KafkaSink) ProcessChannel(ctx context.Context, events chan any, processFn SinkProcessFunc, opts ...ProcessOption) ProcessSummary {
+	return processChannel(ctx, ks, events, processFn.messageFunc(), opts...)
+}
+
+// Disconnect implements `Sink.Disconnect()`
+func (ks *KafkaSink) Disconnect() {
+	err := ks.producer.Close()
+	if err != nil {
+		log.Error().Msgf("KafkaSink: Failed to close the producer. %s", err)
+	}
+}
+
+func (ks *KafkaSink) metricLabels() (string, string) {
+	return config.KafkaDriver, ks.topic
+}
+
+func (ks *KafkaSink) sinkTelemetry() *telemetry {
+	return ks.telemetry
+}
+
+func (ks *KafkaSink) newKafkaMessage(msg *Message) *sarama.ProducerMessage {
+	pmsg := &sarama.ProducerMessage{
+		Topic:     ks.topic,
+		Value:     sarama.ByteEncoder(msg.Payload),
+		Timestamp: msg.EventTime,
+	}
+	if msg.Key != "" {
+		pmsg.Key = sarama.StringEncoder(msg.Key)
+	}
+	for key, value := range msg.Properties {
+		pmsg.Headers = append(pmsg.Headers, sarama.RecordHeader{Key: []byte(key), Value: []byte(value)})
+	}
+	return pmsg
+}
+
+func newSaramaConfig(opt config.KafkaConfig, clientID string) (*sarama.Config, error) {
+	conf := sarama.NewConfig()
+	conf.ClientID = clientID
+	conf.Producer.Return.Successes = true
+
+	if opt.Version != "" {
+		version, err := sarama.ParseKafkaVersion(opt.Version)
+		if err != nil {
+			return nil, err
+		}
+		conf.Version = version
+	}
+
+	switch strings.ToLower(opt.Acks) {
+	case "", "all":
+		conf.Producer.RequiredAcks = sarama.WaitForAll
+	case "leader":
+		conf.Producer.RequiredAcks = sarama.WaitForLocal
+	case "none":
+		conf.Producer.RequiredAcks = sarama.NoResponse
+	default:
+		return nil, fmt.Errorf("unknown acks %s", opt.Acks)
+	}
+
+	switch strings.ToLower(opt.Compression) {
+	case "", "none":
+		conf.Producer.Compression = sarama.CompressionNone
+	case "gzip":
+		conf.Producer.Compression = sarama.CompressionGZIP
+	case "snappy":
+		conf.Producer.Compression = sarama.CompressionSnappy
+	case "lz4":
+		conf.Producer.Compression = sarama.CompressionLZ4
+	case "zstd":
+		conf.Producer.Compression = sarama.CompressionZSTD
+	default:
+		return nil, fmt.Errorf("unknown compression %s", opt.Compression)
+	}
+
+	if opt.TLS != nil {
+		tlsConf, err := newTLSConfig(opt.TLS.CACert, opt.TLS.Cert, opt.TLS.Key, "")
+		if err != nil {
+			return nil, err
+		}
+		conf.Net.TLS.Enable = true
+		conf.Net.TLS.Config = tlsConf
+	}
+
+	if opt.SASL != nil {
+		conf.Net.SASL.Enable = true
+		conf.Net.SASL.User = opt.SASL.Username
+		conf.Net.SASL.Password = opt.SASL.Password
+
+		switch strings.ToUpper(opt.SASL.Mechanism) {
+		case "", sarama.SASLTypePlaintext:
+			conf.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+		case sarama.SASLTypeSCRAMSHA256:
+			conf.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+			conf.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+				return &kafkaSCRAMClient{hashGen: scram.SHA256}
+			}
+		case sarama.SASLTypeSCRAMSHA512:
+			conf.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+			conf.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+				return &kafkaSCRAMClient{hashGen: scram.SHA512}
+			}
+		default:
+			return nil, fmt.Errorf("unsupported sasl mechanism %s", opt.SASL.Mechanism)
+		}
+	}
+
+	return conf, conf.Validate()
+}
+
+// kafkaSCRAMClient implements `sarama.SCRAMClient` using xdg-go/scram
+type kafkaSCRAMClient struct {
+	hashGen scram.HashGeneratorFcn
+	conv    *scram.ClientConversation
+}
+
+func (c *kafkaSCRAMClient) Begin(userName, password, authzID string) error {
+	client, err := c.hashGen.NewClient(userName, password, authzID)
+	if err != nil {
+		return err
+	}
+	c.conv = client.NewConversation()
+	return nil
+}
+
+func (c *kafkaSCRAMClient) Step(challenge string) (string, error) {
+	return c.conv.Step(challenge)
+}
+
+func (c *kafkaSCRAMClient) Done() bool {
+	return c.conv.Done()
+}
@@ -0,0 +1,187 @@
+package stream
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/ashutosh-the-beast/newknox/config"
+)
+
+func TestKafkaSinkFlush(t *testing.T) {
+	const topic = "kmux-events"
+
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader(topic, 0, broker.BrokerID()),
+		"ProduceRequest": sarama.NewMockProduceResponse(t).
+			SetVersion(3).
+			SetError(topic, 0, sarama.ErrNoError),
+	})
+
+	ks := NewKafkaSinkWithConfig(config.KafkaConfig{
+		Brokers:     []string{broker.Addr()},
+		TopicPrefix: "kmux-",
+		Version:     "1.0.0",
+		Acks:        "leader",
+	}, "events", "kmux-test")
+	if err := ks.Connect(); err != nil {
+		t.Fatalf("Connect() = %v", err)
+	}
+	defer ks.Disconnect()
+
+	if err := ks.Flush([]byte("hello")); err != nil {
+		t.Fatalf("Flush() = %v", err)
+	}
+
+	produced := false
+	for _, rr := range broker.History() {
+		if _, ok := rr.Request.(*sarama.ProduceRequest); ok {
+			produced = true
+		}
+	}
+	if !produced {
+		t.Error("Flush() did not send a produce request to the broker")
+	}
+}
+
+func TestKafkaSinkFlushMessage(t *testing.T) {
+	eventTime := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	producer := mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		if msg.Topic != "kmux-events" {
+			t.Errorf("topic = %s, want kmux-events", msg.Topic)
+		}
+		if msg.Key == nil {
+			t.Error("key is not set")
+		} else if key, _ := msg.Key.Encode(); string(key) != "pod-1" {
+			t.Errorf("key = %s, want pod-1", key)
+		}
+		if value, _ := msg.Value.Encode(); string(value) != "hello" {
+			t.Errorf("value = %s, want hello", value)
+		}
+		if !msg.Timestamp.Equal(eventTime) {
+			t.Errorf("timestamp = %v, want %v", msg.Timestamp, eventTime)
+		}
+
+		var headers []string
+		for _, h := range msg.Headers {
+			headers = append(headers, string(h.Key)+"="+string(h.Value))
+		}
+		sort.Strings(headers)
+		if len(headers) != 2 || headers[0] != "cluster=prod" || headers[1] != "source=kubearmor" {
+			t.Errorf("headers = %v, want [cluster=prod source=kubearmor]", headers)
+		}
+		return nil
+	})
+	producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		if msg.Key != nil {
+			t.Errorf("key = %v, want no key", msg.Key)
+		}
+		if len(msg.Headers) != 0 {
+			t.Errorf("headers = %v, want no headers", msg.Headers)
+		}
+		return nil
+	})
+	producer.ExpectSendMessageAndFail(sarama.ErrNotLeaderForPartition)
+
+	ks := NewKafkaSinkWithConfig(config.KafkaConfig{TopicPrefix: "kmux-"}, "events", "kmux-test")
+	ks.producer = producer
+	defer ks.Disconnect()
+
+	err := ks.FlushMessage(&Message{
+		Key:        "pod-1",
+		Properties: map[string]string{"source": "kubearmor", "cluster": "prod"},
+		EventTime:  eventTime,
+		Payload:    []byte("hello"),
+	})
+	if err != nil {
+		t.Fatalf("FlushMessage() = %v", err)
+	}
+
+	if err := ks.Flush([]byte("plain")); err != nil {
+		t.Fatalf("Flush() = %v", err)
+	}
+
+	if err := ks.Flush([]byte("failed")); err == nil {
+		t.Error("Flush() did not return the producer error")
+	}
+}
+
+func TestNewSaramaConfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		options     config.KafkaConfig
+		wantErr     bool
+		acks        sarama.RequiredAcks
+		compression sarama.CompressionCodec
+		mechanism   sarama.SASLMechanism
+	}{
+		{name: "defaults", acks: sarama.WaitForAll, compression: sarama.CompressionNone},
+		{
+			name:    "acks and compression",
+			options: config.KafkaConfig{Acks: "None", Compression: "ZSTD", Version: "2.8.0"},
+			acks:    sarama.NoResponse, compression: sarama.CompressionZSTD,
+		},
+		{name: "unknown acks", options: config.KafkaConfig{Acks: "some"}, wantErr: true},
+		{name: "unknown compression", options: config.KafkaConfig{Compression: "brotli"}, wantErr: true},
+		{name: "invalid version", options: config.KafkaConfig{Version: "latest"}, wantErr: true},
+		{
+			name:    "sasl plain",
+			options: config.KafkaConfig{SASL: &config.KafkaSASLConfig{Username: "kmux", Password: "pass"}},
+			acks:    sarama.WaitForAll, compression: sarama.CompressionNone, mechanism: sarama.SASLTypePlaintext,
+		},
+		{
+			name:    "sasl scram",
+			options: config.KafkaConfig{SASL: &config.KafkaSASLConfig{Mechanism: "scram-sha-512", Username: "kmux", Password: "pass"}},
+			acks:    sarama.WaitForAll, compression: sarama.CompressionNone, mechanism: sarama.SASLTypeSCRAMSHA512,
+		},
+		{
+			name:    "unsupported sasl mechanism",
+			options: config.KafkaConfig{SASL: &config.KafkaSASLConfig{Mechanism: "GSSAPI", Username: "kmux", Password: "pass"}},
+			wantErr: true,
+		},
+		{
+			name:    "sasl without user",
+			options: config.KafkaConfig{SASL: &config.KafkaSASLConfig{Mechanism: "PLAIN"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf, err := newSaramaConfig(tt.options, "kmux-test")
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("newSaramaConfig() succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("newSaramaConfig() = %v", err)
+			}
+
+			if conf.ClientID != "kmux-test" || !conf.Producer.Return.Successes {
+				t.Errorf("client id = %s, return successes = %t", conf.ClientID, conf.Producer.Return.Successes)
+			}
+			if conf.Producer.RequiredAcks != tt.acks {
+				t.Errorf("acks = %v, want %v", conf.Producer.RequiredAcks, tt.acks)
+			}
+			if conf.Producer.Compression != tt.compression {
+				t.Errorf("compression = %v, want %v", conf.Producer.Compression, tt.compression)
+			}
+			if conf.Net.SASL.Enable != (tt.mechanism != "") || (tt.mechanism != "" && conf.Net.SASL.Mechanism != tt.mechanism) {
+				t.Errorf("sasl = %t %s, want %s", conf.Net.SASL.Enable, conf.Net.SASL.Mechanism, tt.mechanism)
+			}
+			if tt.mechanism == sarama.SASLTypeSCRAMSHA512 && conf.Net.SASL.SCRAMClientGeneratorFunc == nil {
+				t.Error("scram client generator is not set")
+			}
+		})
+	}
+}
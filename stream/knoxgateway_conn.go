@@ -0,0 +1,350 @@
+package stream
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ashutosh-the-beast/newknox/config"
+	"github.com/ashutosh-the-beast/newknox/metrics"
+
+	pb "github.com/accuknox/knox-gateway/pkg/grpc/knoxgateway/pb"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// gatewayConn is a gRPC connection and client stream shared by all the
+// sinks publishing to the same AccuKnox gRPC gateway
+type gatewayConn struct {
+	key       string
+	server    string
+	reconnect config.KnoxGatewayReconnectConfig
+	telemetry *telemetry
+
+	conn   *grpc.ClientConn
+	client pb.KnoxGatewayClient
+
+	// mu serializes the sends on the client stream, since gRPC streams
+	// are not safe for concurrent sends. It also guards the fields below.
+	mu           sync.Mutex
+	stream       pb.KnoxGateway_PublishClient
+	cancelStream context.CancelFunc
+	reconnecting bool
+	buffer       []*bufferedEvent
+	done         chan struct{}
+
+	// refs is the number of sinks using the connection. It is guarded by gatewayPool.mu
+	refs uint
+}
+
+// gatewayPool manages the gateway connections keyed by server address and settings,
+// so that sinks with different TLS or token settings do not share a connection
+type gatewayPool struct {
+	mu    sync.Mutex
+	conns map[string]*gatewayConn
+}
+
+// gateways is the connection pool shared by all the KnoxGatewaySinks in the process
+var gateways = &gatewayPool{
+	conns: map[string]*gatewayConn{},
+}
+
+// acquire returns the connection for the server, establishing it if required.
+// The reconnects of the connection are recorded in the metrics of the telemetry.
+func (p *gatewayPool) acquire(options config.KnoxGatewayConfig, t *telemetry) (*gatewayConn, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var m *metrics.Metrics
+	if t != nil {
+		m = t.metrics
+	}
+	key := gatewayKey(options, m)
+	gc, ok := p.conns[key]
+	if !ok {
+		var err error
+		gc, err = dialGateway(options)
+		if err != nil {
+			return nil, err
+		}
+		gc.key = key
+		gc.telemetry = t
+		p.conns[key] = gc
+	}
+
+	gc.refs++
+	return gc, nil
+}
+
+// release drops a reference to the connection and closes it once unused
+func (p *gatewayPool) release(gc *gatewayConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	gc.refs--
+	if gc.refs > 0 {
+		return
+	}
+
+	delete(p.conns, gc.key)
+	gc.close()
+}
+
+// gatewayKey returns the pool key of the connection established with the options,
+// so that the connections of different kmux clients record their own metrics
+func gatewayKey(options config.KnoxGatewayConfig, m *metrics.Metrics) string {
+	tls := config.KnoxGatewayTLSConfig{}
+	if options.TLS != nil {
+		tls = *options.TLS
+	}
+	return fmt.Sprintf("%s|%+v|%s|%+v|%p", options.Server, tls, options.Token, options.Reconnect, m)
+}
+
+// gatewayDialer dials the gateway servers when set, instead of the default TCP dialer.
+// It is set by the tests to serve the gateway in-memory.
+var gatewayDialer func(ctx context.Context, addr string) (net.Conn, error)
+
+// bearerToken implements `credentials.PerRPCCredentials` to authenticate using a bearer token.
+// The token is only sent over TLS connections.
+type bearerToken struct {
+	token string
+}
+
+func (t bearerToken) GetRequestMetadata(_ context.Context, _ ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + t.token}, nil
+}
+
+func (t bearerToken) RequireTransportSecurity() bool {
+	return true
+}
+
+func gatewayDialOptions(options config.KnoxGatewayConfig) ([]grpc.DialOption, error) {
+	var opts []grpc.DialOption
+
+	if options.TLS != nil {
+		tlsConf, err := newTLSConfig(options.TLS.CACert, options.TLS.Cert, options.TLS.Key, options.TLS.ServerName)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(tlsConf)))
+	} else {
+		opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	}
+
+	if options.Token != "" {
+		if options.TLS == nil {
+			return nil, fmt.Errorf("bearer token requires encryption")
+		}
+		opts = append(opts, grpc.WithPerRPCCredentials(bearerToken{token: options.Token}))
+	}
+
+	if gatewayDialer != nil {
+		opts = append(opts, grpc.WithContextDialer(gatewayDialer))
+	}
+	return opts, nil
+}
+
+func dialGateway(options config.KnoxGatewayConfig) (*gatewayConn, error) {
+	dialOpts, err := gatewayDialOptions(options)
+	if err != nil {
+		return nil, fmt.Errorf("KnoxGatewaySink: Invalid TLS configuration for gRPC server %s. Error - %s", options.Server, err)
+	}
+
+	conn, err := grpc.Dial(options.Server, dialOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("KnoxGatewaySink: Failed to dial gRPC server %s. Error - %s", options.Server, err)
+	}
+	log.Info().Msgf("KnoxGatewaySink: Established a new gRPC connection at %s", options.Server)
+
+	client := pb.NewKnoxGatewayClient(conn)
+	stream, cancel, err := openStream(client)
+	if err != nil {
+		cerr := conn.Close()
+		if cerr != nil {
+			return nil, fmt.Errorf("KnoxGatewaySink: Failed to get client stream. Error - %s, Failed to close the connection. Error - %s", err, cerr)
+		}
+		return nil, fmt.Errorf("KnoxGatewaySink: Failed to get client stream. Error - %s", err)
+	}
+	log.Info().Msgf("KnoxGatewaySink: Stream successfully created at %s", options.Server)
+
+	return &gatewayConn{
+		server:    options.Server,
+		reconnect: options.Reconnect,
+		conn:      conn,
+		client:       client,
+		stream:       stream,
+		cancelStream: cancel,
+		done:         make(chan struct{}),
+	}, nil
+}
+
+// openStream creates a client stream, which is terminated by the returned cancel function
+func openStream(client pb.KnoxGatewayClient) (pb.KnoxGateway_PublishClient, context.CancelFunc, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := client.Publish(ctx)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	return stream, cancel, nil
+}
+
+// closeStream cancels the client stream, releasing its resources.
+// It must be called while holding gc.mu.
+func (gc *gatewayConn) closeStream() {
+	if gc.cancelStream != nil {
+		gc.cancelStream()
+	}
+	gc.stream = nil
+	gc.cancelStream = nil
+}
+
+// bufferedEvent is an event waiting for the stream to be re-established
+type bufferedEvent struct {
+	event *pb.PubEvent
+
+	// result receives the outcome of sending the event. It is written while holding gatewayConn.mu.
+	result chan error
+}
+
+// send publishes the event through the client stream. If the stream is broken,
+// the event is buffered and send blocks until it is sent once the stream is
+// re-established, the reconnection fails or the flush timeout expires.
+func (gc *gatewayConn) send(event *pb.PubEvent) error {
+	gc.mu.Lock()
+
+	if gc.stream != nil {
+		err := gc.stream.Send(event)
+		if err == nil {
+			gc.mu.Unlock()
+			return nil
+		}
+		log.Error().Msgf("KnoxGatewaySink: Stream to %s is broken, reconnecting. %s", gc.server, err)
+		gc.closeStream()
+	}
+
+	if !gc.reconnecting {
+		gc.reconnecting = true
+		go gc.reconnectStream()
+	}
+
+	if len(gc.buffer) >= gc.reconnect.BufferSize {
+		gc.mu.Unlock()
+		return fmt.Errorf("reconnecting to %s and the buffer is full", gc.server)
+	}
+	be := &bufferedEvent{event: event, result: make(chan error, 1)}
+	gc.buffer = append(gc.buffer, be)
+	gc.mu.Unlock()
+
+	timer := time.NewTimer(gc.reconnect.FlushTimeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-be.result:
+		return err
+	case <-timer.C:
+	}
+
+	// remove the event from the buffer, unless it got sent meanwhile
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+
+	select {
+	case err := <-be.result:
+		return err
+	default:
+	}
+	for i, e := range gc.buffer {
+		if e == be {
+			gc.buffer = append(gc.buffer[:i], gc.buffer[i+1:]...)
+			break
+		}
+	}
+	return fmt.Errorf("timed out after %s waiting for the stream to %s to be re-established", gc.reconnect.FlushTimeout, gc.server)
+}
+
+// failBuffer reports the error to the flushes of all the buffered events.
+// It must be called while holding gc.mu.
+func (gc *gatewayConn) failBuffer(err error) {
+	for _, be := range gc.buffer {
+		be.result <- err
+	}
+	gc.buffer = nil
+}
+
+// reconnectStream re-establishes the client stream with exponential backoff
+// and sends the events buffered while reconnecting
+func (gc *gatewayConn) reconnectStream() {
+	opt := gc.reconnect
+	b := newBackoff(opt.InitialInterval, opt.MaxInterval, opt.Multiplier, opt.Jitter)
+
+	for attempt := 1; opt.MaxAttempts <= 0 || attempt <= opt.MaxAttempts; attempt++ {
+		select {
+		case <-gc.done:
+			return
+		case <-time.After(b.next()):
+		}
+		gc.telemetry.getMetrics().IncReconnect(config.KnoxGatewayDriver, gc.server)
+
+		stream, cancel, err := openStream(gc.client)
+		if err != nil {
+			log.Error().Msgf("KnoxGatewaySink: Failed to re-create stream to %s (attempt %d). %s", gc.server, attempt, err)
+			continue
+		}
+
+		gc.mu.Lock()
+		for len(gc.buffer) > 0 {
+			if err = stream.Send(gc.buffer[0].event); err != nil {
+				break
+			}
+			gc.buffer[0].result <- nil
+			gc.buffer = gc.buffer[1:]
+		}
+		if err != nil {
+			gc.mu.Unlock()
+			cancel()
+			log.Error().Msgf("KnoxGatewaySink: Failed to send buffered messages to %s (attempt %d). %s", gc.server, attempt, err)
+			continue
+		}
+		gc.stream = stream
+		gc.cancelStream = cancel
+		gc.reconnecting = false
+		gc.mu.Unlock()
+
+		log.Info().Msgf("KnoxGatewaySink: Stream to %s re-established after %d attempt(s)", gc.server, attempt)
+		return
+	}
+
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+
+	log.Error().Msgf("KnoxGatewaySink: Failed to re-establish stream to %s after %d attempts. Dropped %d buffered messages",
+		gc.server, opt.MaxAttempts, len(gc.buffer))
+	gc.failBuffer(fmt.Errorf("failed to re-establish stream to %s after %d attempts", gc.server, opt.MaxAttempts))
+	gc.reconnecting = false
+}
+
+// close terminates the client stream and the connection
+func (gc *gatewayConn) close() {
+	close(gc.done)
+
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+
+	if len(gc.buffer) > 0 {
+		log.Error().Msgf("KnoxGatewaySink: Dropped %d buffered messages while closing the connection to %s", len(gc.buffer), gc.server)
+		gc.failBuffer(fmt.Errorf("connection to %s is closed", gc.server))
+	}
+	if gc.stream != nil {
+		_, _ = gc.stream.CloseAndRecv()
+	}
+	gc.closeStream()
+	err := gc.conn.Close()
+	if err != nil {
+		log.Error().Msgf("KnoxGatewaySink: Failed to close the connection to %s. %s", gc.server, err)
+	}
+}
@@ -0,0 +1,282 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ashutosh-the-beast/newknox/config"
+
+	pb "github.com/accuknox/knox-gateway/pkg/grpc/knoxgateway/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// dropPayload is the payload of the events making the gateway fail the stream
+const dropPayload = "drop"
+
+// startGateway serves an in-memory gateway counting the published events
+func startGateway(t *testing.T) *atomic.Int64 {
+	t.Helper()
+
+	received := &atomic.Int64{}
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(grpc.UnknownServiceHandler(func(_ any, stream grpc.ServerStream) error {
+		for {
+			event := new(pb.PubEvent)
+			err := stream.RecvMsg(event)
+			if errors.Is(err, io.EOF) {
+				return stream.SendMsg(new(pb.PubResponse))
+			}
+			if err != nil {
+				return err
+			}
+			if string(event.Data) == dropPayload {
+				return status.Error(codes.Unavailable, "stream dropped")
+			}
+			received.Add(1)
+		}
+	}))
+	go func() {
+		_ = srv.Serve(lis)
+	}()
+
+	gatewayDialer = func(ctx context.Context, _ string) (net.Conn, error) {
+		return lis.DialContext(ctx)
+	}
+	t.Cleanup(func() {
+		gatewayDialer = nil
+		srv.Stop()
+	})
+	return received
+}
+
+// dropStream makes the gateway fail the stream of the sink, and waits until the
+// client has seen the stream terminate, so that the next flush reconnects
+func dropStream(t *testing.T, sink *KnoxGatewaySink) {
+	t.Helper()
+
+	sink.conn.mu.Lock()
+	stream := sink.conn.stream
+	sink.conn.mu.Unlock()
+
+	if err := sink.Flush([]byte(dropPayload)); err != nil {
+		t.Fatalf("Flush() = %v", err)
+	}
+	if err := stream.RecvMsg(new(pb.PubResponse)); status.Code(err) != codes.Unavailable {
+		t.Fatalf("stream terminated with %v, want the gateway error", err)
+	}
+}
+
+// bufferedEvents returns the number of events buffered while reconnecting
+func bufferedEvents(gc *gatewayConn) int {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	return len(gc.buffer)
+}
+
+func TestGatewayPoolConcurrentSinks(t *testing.T) {
+	received := startGateway(t)
+
+	options := config.KnoxGatewayConfig{
+		Server: "bufnet",
+		Reconnect: config.KnoxGatewayReconnectConfig{
+			InitialInterval: 10 * time.Millisecond,
+			MaxInterval:     100 * time.Millisecond,
+			Multiplier:      2,
+			MaxAttempts:     5,
+			BufferSize:      100,
+			FlushTimeout:    5 * time.Second,
+		},
+	}
+
+	// a long lived sink keeps the connection open while the others come and go
+	long := NewKnoxGatewaySinkWithConfig(options, "long")
+	if err := long.Connect(); err != nil {
+		t.Fatalf("Connect() = %v", err)
+	}
+
+	const sinks, rounds, flushes = 8, 20, 5
+	var wg sync.WaitGroup
+	errs := make(chan error, sinks*rounds*flushes)
+	for i := 0; i < sinks; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for r := 0; r < rounds; r++ {
+				sink := NewKnoxGatewaySinkWithConfig(options, fmt.Sprintf("topic-%d", i))
+				if err := sink.Connect(); err != nil {
+					errs <- err
+					continue
+				}
+				for f := 0; f < flushes; f++ {
+					if err := sink.Flush([]byte("event")); err != nil {
+						errs <- err
+					}
+				}
+				sink.Disconnect()
+			}
+		}(i)
+	}
+
+	// the long lived sink flushes concurrently with the others
+	for f := 0; f < flushes; f++ {
+		if err := long.Flush([]byte("event")); err != nil {
+			t.Errorf("Flush() = %v", err)
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("concurrent sink: %v", err)
+	}
+
+	long.Disconnect()
+
+	gateways.mu.Lock()
+	open := len(gateways.conns)
+	gateways.mu.Unlock()
+	if open != 0 {
+		t.Errorf("%d gateway connections are open after all the sinks disconnected", open)
+	}
+
+	// the streams are closed and acknowledged by the gateway on disconnect
+	want := int64(sinks*rounds*flushes + flushes)
+	if got := received.Load(); got != want {
+		t.Errorf("gateway received %d events, want %d", got, want)
+	}
+}
+
+func TestGatewayPoolConcurrentDisconnects(t *testing.T) {
+	received := startGateway(t)
+
+	options := config.KnoxGatewayConfig{Server: "bufnet", Reconnect: config.KnoxGatewayReconnectConfig{BufferSize: 10}}
+
+	const sinks = 16
+	all := make([]*KnoxGatewaySink, sinks)
+	for i := range all {
+		all[i] = NewKnoxGatewaySinkWithConfig(options, "events")
+		if err := all[i].Connect(); err != nil {
+			t.Fatalf("Connect() = %v", err)
+		}
+	}
+
+	// all the sinks share a single connection, which is closed by the last disconnect
+	if all[0].conn != all[sinks-1].conn {
+		t.Fatal("sinks with the same options do not share the connection")
+	}
+
+	var wg sync.WaitGroup
+	for _, sink := range all {
+		wg.Add(1)
+		go func(sink *KnoxGatewaySink) {
+			defer wg.Done()
+			if err := sink.Flush([]byte("event")); err != nil {
+				t.Errorf("Flush() = %v", err)
+			}
+			sink.Disconnect()
+		}(sink)
+	}
+	wg.Wait()
+
+	gateways.mu.Lock()
+	open := len(gateways.conns)
+	gateways.mu.Unlock()
+	if open != 0 {
+		t.Errorf("%d gateway connections are open after all the sinks disconnected", open)
+	}
+	if got := received.Load(); got != sinks {
+		t.Errorf("gateway received %d events, want %d", got, sinks)
+	}
+}
+
+func TestGatewayReconnect(t *testing.T) {
+	received := startGateway(t)
+
+	// the reconnect is delayed, so that the flushes are buffered meanwhile
+	options := config.KnoxGatewayConfig{
+		Server: "bufnet",
+		Reconnect: config.KnoxGatewayReconnectConfig{
+			InitialInterval: 200 * time.Millisecond,
+			Multiplier:      1,
+			MaxAttempts:     3,
+			BufferSize:      2,
+			FlushTimeout:    5 * time.Second,
+		},
+	}
+	sink := NewKnoxGatewaySinkWithConfig(options, "events")
+	if err := sink.Connect(); err != nil {
+		t.Fatalf("Connect() = %v", err)
+	}
+	if err := sink.Flush([]byte("event")); err != nil {
+		t.Fatalf("Flush() = %v", err)
+	}
+	dropStream(t, sink)
+
+	var wg sync.WaitGroup
+	for i := 0; i < options.Reconnect.BufferSize; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := sink.Flush([]byte("buffered")); err != nil {
+				t.Errorf("Flush() of a buffered message = %v", err)
+			}
+		}()
+	}
+	for bufferedEvents(sink.conn) < options.Reconnect.BufferSize {
+		time.Sleep(time.Millisecond)
+	}
+
+	// the flushes fail right away once the buffer is full
+	if err := sink.Flush([]byte("overflow")); err == nil || !strings.Contains(err.Error(), "buffer is full") {
+		t.Errorf("Flush() with a full buffer = %v, want the buffer to be full", err)
+	}
+	wg.Wait()
+
+	// the stream is closed and acknowledged by the gateway on disconnect
+	sink.Disconnect()
+	if got := received.Load(); got != 3 {
+		t.Errorf("gateway received %d events, want 3", got)
+	}
+}
+
+func TestGatewayReconnectFlushTimeout(t *testing.T) {
+	received := startGateway(t)
+
+	options := config.KnoxGatewayConfig{
+		Server: "bufnet",
+		Reconnect: config.KnoxGatewayReconnectConfig{
+			InitialInterval: time.Minute,
+			BufferSize:      10,
+			FlushTimeout:    50 * time.Millisecond,
+		},
+	}
+	sink := NewKnoxGatewaySinkWithConfig(options, "events")
+	if err := sink.Connect(); err != nil {
+		t.Fatalf("Connect() = %v", err)
+	}
+	dropStream(t, sink)
+
+	// the stream is not re-established before the flush timeout
+	err := sink.Flush([]byte("event"))
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("Flush() = %v, want a timeout", err)
+	}
+	if n := bufferedEvents(sink.conn); n != 0 {
+		t.Errorf("%d events are buffered after the flush timed out, want none", n)
+	}
+
+	sink.Disconnect()
+	if got := received.Load(); got != 0 {
+		t.Errorf("gateway received %d events, want none", got)
+	}
+}
@@ -3,142 +3,113 @@ package stream
 import (
 	"context"
 	"fmt"
-	"sync"
+	"time"
 
 	"github.com/ashutosh-the-beast/newknox/config"
 
 	pb "github.com/accuknox/knox-gateway/pkg/grpc/knoxgateway/pb"
 	"github.com/rs/zerolog/log"
-	"google.golang.org/grpc"
 )
 
-var (
-	mu     sync.Mutex
-	conn   *grpc.ClientConn
-	stream pb.KnoxGateway_PublishClient
-	count  uint
-)
-
-// KnoxGatewaySink implements `stream.Sink` interface for AccuKnox GRPC gateway
+// KnoxGatewaySink implements `stream.Sink` interface for AccuKnox GRPC gateway.
+// Sinks publishing to the same gateway server share a single gRPC connection.
 type KnoxGatewaySink struct {
-	topic string
+	topic   string
+	options config.KnoxGatewayConfig
+	conn    *gatewayConn
+
+	telemetry *telemetry
 }
 
 // NewKnoxGatewaySink returns a stream sink for gRPC gateway.
 func NewKnoxGatewaySink(Topic string) *KnoxGatewaySink {
+	return NewKnoxGatewaySinkWithConfig(config.Current().KnoxGateway, Topic)
+}
+
+// NewKnoxGatewaySinkWithServer returns a stream sink for the gRPC gateway running at the given server address.
+func NewKnoxGatewaySinkWithServer(server, topic string) *KnoxGatewaySink {
+	options := config.Current().KnoxGateway
+	options.Server = server
+	return NewKnoxGatewaySinkWithConfig(options, topic)
+}
+
+// NewKnoxGatewaySinkWithConfig returns a stream sink for gRPC gateway with the given configuration.
+func NewKnoxGatewaySinkWithConfig(cfg config.KnoxGatewayConfig, topic string) *KnoxGatewaySink {
 	return &KnoxGatewaySink{
-		topic: Topic,
+		topic:   topic,
+		options: cfg,
 	}
 }
 
 // Connect implements `Sink.Connect()`
 func (kg *KnoxGatewaySink) Connect() (err error) {
-	//locking the mutex
-	mu.Lock()
-
-	//Unlocking it after a single execution.
-	defer mu.Unlock()
-
-	// if count == 0 || conn == nil {
-	// 	conn, err = grpc.Dial(config.KnoxGateway.Server, grpc.WithInsecure(), grpc.WithTimeout(5000))
-	// 	if err != nil {
-	// 		return fmt.Errorf("Failed to dial GRPC Server : Error - %s", err.Error())
-	// 	}
-	// 	if conn == nil {
-	// 		return fmt.Errorf("Failed to dial GRPC Server : Error - %s", err.Error())
-	// 	}
-
-	fmt.Println("Connection Stablished ::::::: Mega Star Soorya")
-
-	if count == 0 {
-		conn, err = grpc.Dial(config.KnoxGateway.Server, grpc.WithInsecure())
-		if err != nil {
-			return fmt.Errorf("Failed to dial GRPC Server : Error - %s", err.Error())
-		}
-		log.Info().Msg("Established a new gRPC connection at =" + config.KnoxGateway.Server)
-
-		client := pb.NewKnoxGatewayClient(conn)
-		stream, err = client.Publish(context.Background())
-		if err != nil {
-			cerr := conn.Close()
-			if cerr != nil {
-				return fmt.Errorf("KnoxGatewaySink: Failed to close the connection , Failed to get client streeam . connectionerr - %s ,streamerr -%s  ", cerr, err)
-			}
-			return fmt.Errorf("KnoxGatewaySink: Failed to get client streeam. Error - %s", err)
-		}
-		log.Info().Msg("KnoxGatewayStream : Stream successfully created ")
-	}
-	if conn != nil && stream != nil {
-		count = count + 1
+	if kg.conn != nil {
+		return nil
 	}
 
-	return nil
+	kg.conn, err = gateways.acquire(kg.options, kg.telemetry)
+	return err
 }
 
 // Flush implements `sink.Flush()`
 func (kg *KnoxGatewaySink) Flush(data []byte) error {
+	return kg.FlushMessage(&Message{Payload: data})
+}
+
+// FlushMessage implements `sink.FlushMessage()`.
+// The gateway protocol only carries the topic and the payload,
+// hence the key, properties and event time of the message are not sent.
+// For the same reason, the trace context of the producer span is not propagated.
+//
+// If the stream with the gateway is broken, the message is buffered and sent
+// once the stream is re-established in the background. The call blocks until
+// the buffered message is sent, and fails if the stream can not be re-established
+// within `knox-gateway.reconnect.flush-timeout`.
+func (kg *KnoxGatewaySink) FlushMessage(message *Message) error {
+	data := message.Payload
 
 	//creating a payload according to proto.
 	Payload := pb.PubEvent{Topic: kg.topic, Data: data}
 
 	//Checking wheather we have a stream configured or not .
-	// if stream == nil || conn == nil {
-	// 	//It will try to reconnect for 5 times .
-	// 	for i := 0; i <= 5; i++ {
-	// 		err := kg.Connect()
-	// 		if err != nil {
-	// 			time.Sleep(3 * time.Second)
-	// 			continue
-	// 		} else {
-	// 			break
-	// 		}
-	// 	}
-	// 	if conn == nil || stream == nil {
-	// 		return fmt.Errorf("KnoxGatewaySink: Failed to send message. Uninitialized stream/Connection ")
-	// 	}
-	// }
-
-	//Checking wheather we have a stream configured or not .
-	if stream == nil {
+	if kg.conn == nil {
 		return fmt.Errorf("KnoxGatewaySink: Failed to send message. Uninitialized stream")
 	}
 
-	err := stream.Send(&Payload)
+	_, span := kg.telemetry.startProducerSpan(config.KnoxGatewayDriver, kg.topic, message)
+	start := time.Now()
+	err := kg.conn.send(&Payload)
+	kg.telemetry.getMetrics().ObserveFlush(config.KnoxGatewayDriver, kg.topic, len(data), time.Since(start), err)
+	endSpan(span, err)
 	if err != nil {
 		return fmt.Errorf("KnoxGatewaySink: Failed to send message. Topic - %s, Message - %s, Error - %s", kg.topic, string(data), err)
 	}
-	var msg string
-	if len(data) > 100 {
-		msg = string(data[:100]) + "..."
-	} else {
-		msg = string(data)
-	}
-	log.Info().Msgf("KnoxGatewaySink: Topic - %s | Message - %s", kg.topic, msg)
+	log.Info().Msgf("KnoxGatewaySink: Topic - %s | Message - %s", kg.topic, truncatePayload(data))
 	return nil
 }
 
 // Disconnect implements `Sink.Disconnect()`
 func (kg *KnoxGatewaySink) Disconnect() {
-	mu.Lock()
-	defer mu.Unlock()
-
 	//Checking wheather we have a stream configured or not .
-	if stream == nil || conn == nil {
+	if kg.conn == nil {
 		log.Error().Msg("KnoxGatewaySink: Failed to Disconnect. Uninitialized stream")
-	} else {
-		count = count - 1
-		if count == 0 {
-			//Closing the stream/connection once there are no stream/conn left.
-			_, _ = stream.CloseAndRecv()
-			err := conn.Close()
-			if err != nil {
-				log.Error().Msg("KnoxGatewaySink: Failed to close the connection :" + err.Error())
-			}
-		}
+		return
 	}
+
+	//Closing the stream/connection once there are no sinks left.
+	gateways.release(kg.conn)
+	kg.conn = nil
 }
 
 // ProcessChannel implements `Sink.ProcessChannel()`
-func (kg *KnoxGatewaySink) ProcessChannel(ctx context.Context, events chan any, processFn SinkProcessFunc) {
-	log.Info().Msg("KnoxGatewaySink: Process Channel is not Implemented yet")
+func (kg *KnoxGatewaySink) ProcessChannel(ctx context.Context, events chan any, processFn SinkProcessFunc, opts ...ProcessOption) ProcessSummary {
+	return processChannel(ctx, kg, events, processFn.messageFunc(), opts...)
+}
+
+func (kg *KnoxGatewaySink) metricLabels() (string, string) {
+	return config.KnoxGatewayDriver, kg.topic
+}
+
+func (kg *KnoxGatewaySink) sinkTelemetry() *telemetry {
+	return kg.telemetry
 }
@@ -0,0 +1,50 @@
+package stream
+
+import (
+	"context"
+	"time"
+)
+
+// Message is a payload along with the metadata to be sent through a stream sink
+type Message struct {
+	// Key is used for partitioning and ordering the messages. Messages
+	// with the same key are delivered in order to the same consumer.
+	Key string
+
+	// Properties are user defined key/value pairs attached to the message
+	Properties map[string]string
+
+	// EventTime is the application defined time of the event
+	EventTime time.Time
+
+	// Payload is the message data
+	Payload []byte
+
+	// ctx carries the trace context set by WithContext()
+	ctx context.Context
+}
+
+// SinkMessageFunc describes the prototype for functions that can be passed to ProcessMessages().
+// It is similar to SinkProcessFunc, but allows setting the message metadata.
+type SinkMessageFunc func(any) (*Message, error)
+
+// toMessage converts the data sent through a sink channel into a message
+func toMessage(data any) (*Message, bool) {
+	switch msg := data.(type) {
+	case []byte:
+		return &Message{Payload: msg}, true
+	case *Message:
+		return msg, msg != nil
+	case Message:
+		return &msg, true
+	}
+	return nil, false
+}
+
+// truncatePayload returns the payload as string, truncated to a loggable length
+func truncatePayload(data []byte) string {
+	if len(data) > 100 {
+		return string(data[:100]) + "..."
+	}
+	return string(data)
+}
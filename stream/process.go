@@ -0,0 +1,456 @@
+package stream
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ProcessError describes a message dropped while processing a sink channel
+type ProcessError struct {
+	// Data is the value received from the sink channel
+	Data any
+
+	// Message is the message failed to be flushed. It is nil when
+	// the data could not be converted into a message.
+	Message *Message
+
+	// DeadLettered reports whether the message was stored in the dead letter destination
+	DeadLettered bool
+
+	// Err is the cause of the failure
+	Err error
+}
+
+func (e *ProcessError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ProcessError) Unwrap() error {
+	return e.Err
+}
+
+// ProcessSummary reports the outcome of processing a sink channel
+type ProcessSummary struct {
+	// Sent is the number of messages flushed through the sink
+	Sent int
+
+	// Failed is the number of messages which could not be processed or flushed
+	Failed int
+
+	// DeadLettered is the number of failed messages stored in the dead letter destination
+	DeadLettered int
+
+	// Abandoned is the number of messages left in the channel when the processing stopped
+	Abandoned int
+}
+
+// ProcessOption configures the processing of a sink channel
+type ProcessOption func(*processOptions)
+
+type processOptions struct {
+	errorHandler  func(*ProcessError)
+	retries       int
+	retryInterval time.Duration
+	deadLetter    DeadLetter
+	drainTimeout  time.Duration
+	workers       int
+	ordered       bool
+	keyFn         func(any) string
+}
+
+// WithErrorHandler registers a function called for every message which could not
+// be processed or flushed. The function is called from the processing goroutine,
+// or concurrently from the workers when used along with WithWorkers().
+func WithErrorHandler(fn func(*ProcessError)) ProcessOption {
+	return func(o *processOptions) {
+		o.errorHandler = fn
+	}
+}
+
+// WithErrorChannel sends every message which could not be processed or flushed
+// to the channel. Processing blocks while the channel is full.
+func WithErrorChannel(errs chan<- *ProcessError) ProcessOption {
+	return WithErrorHandler(func(err *ProcessError) {
+		errs <- err
+	})
+}
+
+// WithRetry retries a failed flush up to the given number of times, waiting interval between attempts
+func WithRetry(retries int, interval time.Duration) ProcessOption {
+	return func(o *processOptions) {
+		o.retries = retries
+		o.retryInterval = interval
+	}
+}
+
+// WithDeadLetter sends the messages which could not be flushed after all the retries
+// to the dead letter destination
+func WithDeadLetter(dl DeadLetter) ProcessOption {
+	return func(o *processOptions) {
+		o.deadLetter = dl
+	}
+}
+
+// WithDrain keeps flushing the messages already queued in the channel for up to
+// the given timeout once the context is cancelled, instead of abandoning them.
+// The flushes in progress at the cancellation keep retrying until the timeout as well.
+// New messages sent to the channel after the cancellation may be abandoned.
+// A flush in progress when the timeout expires is not interrupted.
+func WithDrain(timeout time.Duration) ProcessOption {
+	return func(o *processOptions) {
+		o.drainTimeout = timeout
+	}
+}
+
+// WithWorkers processes the messages using the given number of concurrent workers,
+// so that the processing function and the flushes of different messages overlap.
+// By default, the messages are sent in no particular order. Use WithOrderingKey()
+// to preserve the order of messages sharing the same key.
+func WithWorkers(workers int) ProcessOption {
+	return func(o *processOptions) {
+		o.workers = workers
+	}
+}
+
+// WithOrderingKey preserves the order of the messages having the same ordering key
+// when processed by multiple workers, by always processing them on the same worker.
+// keyFn returns the ordering key for the data received from the channel. If keyFn
+// is nil, the key of Message and *Message values is used. Data with an empty key
+// is distributed among the workers in no particular order.
+func WithOrderingKey(keyFn func(any) string) ProcessOption {
+	return func(o *processOptions) {
+		o.ordered = true
+		o.keyFn = keyFn
+	}
+}
+
+// ProcessMessages actively fetch messages from the channel and calls SinkMessageFunc
+// on each message. The message returned from SinkMessageFunc will be flushed through
+// the sink using Sink.FlushMessage(). If SinkMessageFunc is nil, the channel is
+// expected to carry []byte, Message or *Message values.
+//
+// It follows the same semantics as Sink.ProcessChannel().
+func ProcessMessages(ctx context.Context, sink Sink, events chan any, processFn SinkMessageFunc, opts ...ProcessOption) ProcessSummary {
+	return processChannel(ctx, sink, events, processFn, opts...)
+}
+
+// messageFunc adapts SinkProcessFunc to SinkMessageFunc
+func (fn SinkProcessFunc) messageFunc() SinkMessageFunc {
+	if fn == nil {
+		return nil
+	}
+	return func(data any) (*Message, error) {
+		bytes, err := fn(data)
+		if err != nil {
+			return nil, err
+		}
+		return &Message{Payload: bytes}, nil
+	}
+}
+
+// processor holds the state of a sink channel processing loop
+type processor struct {
+	name      string
+	driver    string
+	topic     string
+	telemetry *telemetry
+	sink      Sink
+	processFn SinkMessageFunc
+	processOptions
+
+	mu      sync.Mutex
+	summary ProcessSummary
+
+	// queues feed the workers. In unordered mode, all the workers share a single queue.
+	queues []chan job
+	next   uint32
+	wg     sync.WaitGroup
+}
+
+// job is a data received from the channel to be processed by a worker
+type job struct {
+	ctx  context.Context
+	data any
+}
+
+// processChannel implements the channel processing loop shared by all the sink drivers
+func processChannel(ctx context.Context, sink Sink, events chan any, processFn SinkMessageFunc, opts ...ProcessOption) ProcessSummary {
+	return runProcessor(ctx, newProcessor(sink, processFn, opts...), events)
+}
+
+// newProcessor returns a processor flushing the messages through the sink
+func newProcessor(sink Sink, processFn SinkMessageFunc, opts ...ProcessOption) *processor {
+	p := &processor{
+		name:      sinkName(sink),
+		sink:      sink,
+		processFn: processFn,
+	}
+	p.driver, p.topic = metricLabels(sink)
+	p.telemetry = sinkTelemetry(sink)
+	for _, opt := range opts {
+		opt(&p.processOptions)
+	}
+	return p
+}
+
+// runProcessor processes the values received from the channel until it is closed or the context is cancelled
+func runProcessor[T any](ctx context.Context, p *processor, events <-chan T) ProcessSummary {
+	// the messages are processed under flushCtx, which outlives ctx until the drain timeout
+	flushCtx, cancel := p.flushContext(ctx)
+	defer cancel()
+
+	p.startWorkers()
+
+	for {
+		select {
+		case <-ctx.Done():
+			drainChannel(flushCtx, p, events)
+			return p.result()
+		case data, ok := <-events:
+			if !ok {
+				log.Info().Msgf("%s: Processed all the messages from sink channel", p.name)
+				return p.result()
+			}
+			p.dispatch(flushCtx, data)
+			p.telemetry.getMetrics().SetQueueDepth(p.driver, p.topic, len(events))
+		}
+	}
+}
+
+// flushContext returns the context of the flushes. Without WithDrain, it is ctx. Otherwise, it is
+// cancelled once the drain timeout expires after ctx is cancelled, or when cancel is called.
+func (p *processor) flushContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if p.drainTimeout <= 0 {
+		return ctx, func() {}
+	}
+
+	flushCtx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-flushCtx.Done():
+			return
+		case <-ctx.Done():
+		}
+
+		timer := time.NewTimer(p.drainTimeout)
+		defer timer.Stop()
+		select {
+		case <-flushCtx.Done():
+		case <-timer.C:
+			cancel()
+		}
+	}()
+	return flushCtx, cancel
+}
+
+// startWorkers starts the workers, if the channel is processed concurrently
+func (p *processor) startWorkers() {
+	if p.workers <= 1 {
+		return
+	}
+
+	queues := 1
+	if p.ordered {
+		queues = p.workers
+	}
+	for i := 0; i < queues; i++ {
+		p.queues = append(p.queues, make(chan job))
+	}
+
+	for i := 0; i < p.workers; i++ {
+		queue := p.queues[i%queues]
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for j := range queue {
+				p.process(j.ctx, j.data)
+			}
+		}()
+	}
+}
+
+// stopWorkers waits for the workers to process the dispatched messages
+func (p *processor) stopWorkers() {
+	for _, queue := range p.queues {
+		close(queue)
+	}
+	p.queues = nil
+	p.wg.Wait()
+}
+
+// result waits for the workers and returns the summary of processing
+func (p *processor) result() ProcessSummary {
+	p.stopWorkers()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.summary
+}
+
+// dispatch processes the data inline or hands it over to a worker. The data is
+// abandoned if the context is cancelled while waiting for a worker.
+func (p *processor) dispatch(ctx context.Context, data any) {
+	if len(p.queues) == 0 {
+		p.process(ctx, data)
+		return
+	}
+
+	queue := p.queues[0]
+	if p.ordered {
+		queue = p.queues[p.queueIndex(data)]
+	}
+	select {
+	case queue <- job{ctx: ctx, data: data}:
+	case <-ctx.Done():
+		p.update(func(s *ProcessSummary) { s.Abandoned++ })
+	}
+}
+
+// queueIndex returns the index of the worker queue for the data based on its ordering key
+func (p *processor) queueIndex(data any) int {
+	var key string
+	if p.keyFn != nil {
+		key = p.keyFn(data)
+	} else if msg, ok := toMessage(data); ok {
+		key = msg.Key
+	}
+
+	if key == "" {
+		p.next++
+		return int(p.next % uint32(len(p.queues)))
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum32() % uint32(len(p.queues)))
+}
+
+// update applies fn to the summary of processing
+func (p *processor) update(fn func(*ProcessSummary)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fn(&p.summary)
+}
+
+// drainChannel flushes the messages queued in the channel until it is empty or the
+// drain timeout expires, i.e. ctx returned by flushContext() is cancelled
+func drainChannel[T any](ctx context.Context, p *processor, events <-chan T) {
+	if p.drainTimeout <= 0 {
+		abandoned := len(events)
+		p.update(func(s *ProcessSummary) { s.Abandoned += abandoned })
+		return
+	}
+
+	log.Info().Msgf("%s: Draining %d messages from sink channel", p.name, len(events))
+	for {
+		select {
+		case <-ctx.Done():
+			abandoned := len(events)
+			p.update(func(s *ProcessSummary) { s.Abandoned += abandoned })
+			log.Error().Msgf("%s: Drain timed out. Abandoned %d messages", p.name, abandoned)
+			return
+		case data, ok := <-events:
+			if !ok {
+				return
+			}
+			p.dispatch(ctx, data)
+			p.telemetry.getMetrics().SetQueueDepth(p.driver, p.topic, len(events))
+		default:
+			return
+		}
+	}
+}
+
+// process converts the data into a message and flushes it through the sink
+func (p *processor) process(ctx context.Context, data any) {
+	var msg *Message
+	var err error
+	if p.processFn != nil {
+		msg, err = p.processFn(data)
+		if err != nil {
+			err = fmt.Errorf("%s: Failed to process message from sink channel. msg=%s, err=%s", p.name, data, err)
+		}
+	} else {
+		var ok bool
+		msg, ok = toMessage(data)
+		if !ok {
+			err = fmt.Errorf("%s: Invalid data type sent through sink channel", p.name)
+		}
+	}
+	if err != nil {
+		log.Error().Msg(err.Error())
+		p.update(func(s *ProcessSummary) { s.Failed++ })
+		p.reportError(&ProcessError{Data: data, Err: err})
+		return
+	}
+
+	err = p.flush(ctx, msg)
+	if err == nil {
+		p.update(func(s *ProcessSummary) { s.Sent++ })
+		return
+	}
+	log.Error().Msg(err.Error())
+	p.update(func(s *ProcessSummary) { s.Failed++ })
+
+	perr := &ProcessError{Data: data, Message: msg, Err: err}
+	if p.deadLetter != nil {
+		if dlErr := p.deadLetter.Send(msg, err); dlErr != nil {
+			log.Error().Msgf("%s: Failed to send message to dead letter. %s", p.name, dlErr)
+		} else {
+			perr.DeadLettered = true
+			p.update(func(s *ProcessSummary) { s.DeadLettered++ })
+		}
+	}
+	p.reportError(perr)
+}
+
+// flush sends the message through the sink, retrying on failures
+func (p *processor) flush(ctx context.Context, msg *Message) error {
+	err := p.sink.FlushMessage(msg)
+	for attempt := 1; err != nil && attempt <= p.retries; attempt++ {
+		log.Error().Msgf("%s: Retrying to send message (attempt %d/%d). %s", p.name, attempt, p.retries, err)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(p.retryInterval):
+		}
+		err = p.sink.FlushMessage(msg)
+	}
+	return err
+}
+
+func (p *processor) reportError(err *ProcessError) {
+	if p.errorHandler != nil {
+		p.errorHandler(err)
+	}
+}
+
+// metricLabels returns the driver and topic labels of the sink metrics.
+// Sinks not provided by kmux are labelled by their type name.
+func metricLabels(sink Sink) (driver, topic string) {
+	if s, ok := sink.(interface{ metricLabels() (string, string) }); ok {
+		return s.metricLabels()
+	}
+	return sinkName(sink), ""
+}
+
+// sinkTelemetry returns the telemetry of the sink. Sinks not provided by kmux use the default telemetry.
+func sinkTelemetry(sink Sink) *telemetry {
+	if s, ok := sink.(interface{ sinkTelemetry() *telemetry }); ok {
+		return s.sinkTelemetry()
+	}
+	return nil
+}
+
+// sinkName returns the type name of the sink used as prefix in logs, i.e. `PulsarSink`
+func sinkName(sink Sink) string {
+	name := fmt.Sprintf("%T", sink)
+	return name[strings.LastIndex(name, ".")+1:]
+}
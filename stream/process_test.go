@@ -0,0 +1,372 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var errTransient = errors.New("transient failure")
+
+// fakeSink records the flushed messages. The flushes of the payloads in fail
+// fail the given number of times, or always if negative.
+type fakeSink struct {
+	mu   sync.Mutex
+	sent []*Message
+	fail map[string]int
+
+	// block, if set, is called before every flush, i.e. to hold the flush
+	block func(*Message)
+}
+
+func newFakeSink() *fakeSink {
+	return &fakeSink{fail: map[string]int{}}
+}
+
+func (fs *fakeSink) Connect() error {
+	return nil
+}
+
+func (fs *fakeSink) Flush(data []byte) error {
+	return fs.FlushMessage(&Message{Payload: data})
+}
+
+func (fs *fakeSink) FlushMessage(msg *Message) error {
+	if fs.block != nil {
+		fs.block(msg)
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	payload := string(msg.Payload)
+	if n := fs.fail[payload]; n != 0 {
+		if n > 0 {
+			fs.fail[payload]--
+		}
+		return errTransient
+	}
+	fs.sent = append(fs.sent, msg)
+	return nil
+}
+
+func (fs *fakeSink) Disconnect() {}
+
+func (fs *fakeSink) ProcessChannel(ctx context.Context, events chan any, processFn SinkProcessFunc, opts ...ProcessOption) ProcessSummary {
+	return processChannel(ctx, fs, events, processFn.messageFunc(), opts...)
+}
+
+func (fs *fakeSink) sentPayloads() []string {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	payloads := make([]string, len(fs.sent))
+	for i, msg := range fs.sent {
+		payloads[i] = string(msg.Payload)
+	}
+	return payloads
+}
+
+func TestProcessDrainRetriesFlushInProgress(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	sink := newFakeSink()
+	sink.fail["event"] = 1
+	var once sync.Once
+	sink.block = func(*Message) {
+		once.Do(func() {
+			close(started)
+			<-release
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := make(chan any, 1)
+	events <- []byte("event")
+
+	done := make(chan ProcessSummary)
+	go func() {
+		done <- sink.ProcessChannel(ctx, events, nil, WithDrain(5*time.Second), WithRetry(3, 10*time.Millisecond))
+	}()
+
+	// the first attempt fails after the cancellation, and is retried within the drain timeout
+	<-started
+	cancel()
+	close(release)
+
+	summary := <-done
+	if summary != (ProcessSummary{Sent: 1}) {
+		t.Errorf("ProcessChannel() = %+v, want {Sent:1}", summary)
+	}
+}
+
+func TestProcessDrainTimeoutWithBusyWorkers(t *testing.T) {
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+
+	sink := newFakeSink()
+	sink.block = func(*Message) {
+		started <- struct{}{}
+		<-release
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := make(chan any, 5)
+	for i := 0; i < 2; i++ {
+		events <- []byte("in-flight")
+	}
+
+	done := make(chan ProcessSummary)
+	go func() {
+		done <- sink.ProcessChannel(ctx, events, nil, WithWorkers(2), WithDrain(100*time.Millisecond))
+	}()
+
+	// both the workers are busy, hence the queued messages can not be dispatched before the drain timeout
+	<-started
+	<-started
+	for i := 0; i < 3; i++ {
+		events <- []byte("queued")
+	}
+	cancel()
+
+	time.AfterFunc(500*time.Millisecond, func() { close(release) })
+	summary := <-done
+	if summary != (ProcessSummary{Sent: 2, Abandoned: 3}) {
+		t.Errorf("ProcessChannel() = %+v, want {Sent:2 Abandoned:3}", summary)
+	}
+}
+
+// fakeDeadLetter records the dead lettered messages, or fails if err is set
+type fakeDeadLetter struct {
+	mu       sync.Mutex
+	messages []*Message
+	err      error
+}
+
+func (dl *fakeDeadLetter) Send(msg *Message, _ error) error {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+
+	if dl.err != nil {
+		return dl.err
+	}
+	dl.messages = append(dl.messages, msg)
+	return nil
+}
+
+// processAll processes the values through the sink until the channel is closed
+func processAll(sink Sink, processFn SinkMessageFunc, values []any, opts ...ProcessOption) ProcessSummary {
+	events := make(chan any, len(values))
+	for _, v := range values {
+		events <- v
+	}
+	close(events)
+	return ProcessMessages(context.Background(), sink, events, processFn, opts...)
+}
+
+func TestProcessRetry(t *testing.T) {
+	sink := newFakeSink()
+	sink.fail["recovers"] = 2
+	sink.fail["fails"] = 3
+
+	values := []any{[]byte("ok"), []byte("recovers"), []byte("fails")}
+	summary := processAll(sink, nil, values, WithRetry(2, time.Millisecond))
+
+	if summary != (ProcessSummary{Sent: 2, Failed: 1}) {
+		t.Errorf("ProcessMessages() = %+v, want {Sent:2 Failed:1}", summary)
+	}
+	if got := sink.sentPayloads(); len(got) != 2 || got[0] != "ok" || got[1] != "recovers" {
+		t.Errorf("sent = %v, want [ok recovers]", got)
+	}
+	if remaining := sink.fail["fails"]; remaining != 0 {
+		t.Errorf("failing message was flushed %d times, want 3", 3-remaining)
+	}
+}
+
+func TestProcessDeadLetter(t *testing.T) {
+	sink := newFakeSink()
+	sink.fail["fails"] = -1
+
+	dl := &fakeDeadLetter{}
+	errs := make(chan *ProcessError, 10)
+	values := []any{[]byte("ok"), []byte("fails"), 42}
+	summary := processAll(sink, nil, values, WithRetry(1, time.Millisecond), WithDeadLetter(dl), WithErrorChannel(errs))
+	close(errs)
+
+	// invalid data can not be converted into a message, hence it is not dead lettered
+	if summary != (ProcessSummary{Sent: 1, Failed: 2, DeadLettered: 1}) {
+		t.Errorf("ProcessMessages() = %+v, want {Sent:1 Failed:2 DeadLettered:1}", summary)
+	}
+	if len(dl.messages) != 1 || string(dl.messages[0].Payload) != "fails" {
+		t.Errorf("dead lettered = %v, want the failed message", dl.messages)
+	}
+
+	var deadLettered, invalid int
+	for perr := range errs {
+		switch {
+		case perr.DeadLettered:
+			deadLettered++
+			if !errors.Is(perr, errTransient) || string(perr.Message.Payload) != "fails" {
+				t.Errorf("dead lettered error = %v, message %v", perr, perr.Message)
+			}
+		case perr.Message == nil && perr.Data == 42:
+			invalid++
+		default:
+			t.Errorf("unexpected process error %v", perr)
+		}
+	}
+	if deadLettered != 1 || invalid != 1 {
+		t.Errorf("reported %d dead lettered and %d invalid messages, want 1 each", deadLettered, invalid)
+	}
+}
+
+func TestProcessDeadLetterFailure(t *testing.T) {
+	sink := newFakeSink()
+	sink.fail["fails"] = -1
+
+	dl := &fakeDeadLetter{err: errors.New("dead letter unavailable")}
+	errs := make(chan *ProcessError, 10)
+	summary := processAll(sink, nil, []any{[]byte("fails")}, WithDeadLetter(dl), WithErrorChannel(errs))
+	close(errs)
+
+	if summary != (ProcessSummary{Failed: 1}) {
+		t.Errorf("ProcessMessages() = %+v, want {Failed:1}", summary)
+	}
+	perr := <-errs
+	if perr == nil || perr.DeadLettered || !errors.Is(perr, errTransient) {
+		t.Errorf("process error = %+v, want the flush error without dead lettering", perr)
+	}
+}
+
+func TestProcessErrorChannel(t *testing.T) {
+	sink := newFakeSink()
+	processFn := func(data any) (*Message, error) {
+		s, ok := data.(string)
+		if !ok {
+			return nil, errors.New("not a string")
+		}
+		return &Message{Key: s, Payload: []byte(s)}, nil
+	}
+
+	errs := make(chan *ProcessError, 10)
+	summary := processAll(sink, processFn, []any{"a", 1, "b", 2.5}, WithErrorChannel(errs), WithWorkers(3))
+	close(errs)
+
+	if summary != (ProcessSummary{Sent: 2, Failed: 2}) {
+		t.Errorf("ProcessMessages() = %+v, want {Sent:2 Failed:2}", summary)
+	}
+
+	failed := map[any]bool{}
+	for perr := range errs {
+		if perr.Message != nil {
+			t.Errorf("process error %v has a message", perr)
+		}
+		failed[perr.Data] = true
+	}
+	if len(failed) != 2 || !failed[1] || !failed[2.5] {
+		t.Errorf("reported data = %v, want [1 2.5]", failed)
+	}
+}
+
+func TestProcessAbandoned(t *testing.T) {
+	sink := newFakeSink()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	events := make(chan any, 3)
+	for i := 0; i < 3; i++ {
+		events <- []byte("event")
+	}
+
+	// the processing stops right away, the messages are abandoned without WithDrain
+	summary := sink.ProcessChannel(ctx, events, nil)
+	if summary.Abandoned+summary.Sent != 3 {
+		t.Errorf("ProcessChannel() = %+v, want all the messages sent or abandoned", summary)
+	}
+
+	// the abandoned messages are left in the channel, hence a new channel is used
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	events = make(chan any, 3)
+	for i := 0; i < 3; i++ {
+		events <- []byte("event")
+	}
+	summary = sink.ProcessChannel(ctx, events, nil, WithDrain(time.Second))
+	if summary != (ProcessSummary{Sent: 3}) {
+		t.Errorf("ProcessChannel() with drain = %+v, want {Sent:3}", summary)
+	}
+}
+
+func TestProcessOrderingKey(t *testing.T) {
+	const keys, perKey, workers = 3, 50, 8
+
+	// the messages of the keys are interleaved, i.e. key-0/0, key-1/0, ..., key-0/1, ...
+	var messages []any
+	for seq := 0; seq < perKey; seq++ {
+		for k := 0; k < keys; k++ {
+			key := fmt.Sprintf("key-%d", k)
+			messages = append(messages, &Message{Key: key, Payload: []byte(fmt.Sprintf("%s/%d", key, seq))})
+		}
+	}
+
+	tests := []struct {
+		name      string
+		values    []any
+		keyFn     func(any) string
+		processFn SinkMessageFunc
+	}{
+		{name: "message keys", values: messages},
+		{
+			name:   "key function",
+			values: payloads(messages),
+			keyFn: func(data any) string {
+				key, _, _ := strings.Cut(data.(string), "/")
+				return key
+			},
+			processFn: func(data any) (*Message, error) {
+				return &Message{Payload: []byte(data.(string))}, nil
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := newFakeSink()
+			// there are more workers than keys, and the random delays let the workers overtake each other
+			sink.block = func(*Message) {
+				time.Sleep(time.Duration(rand.Intn(500)) * time.Microsecond)
+			}
+
+			summary := processAll(sink, tt.processFn, tt.values, WithWorkers(workers), WithOrderingKey(tt.keyFn))
+			if summary != (ProcessSummary{Sent: keys * perKey}) {
+				t.Fatalf("ProcessMessages() = %+v, want {Sent:%d}", summary, keys*perKey)
+			}
+
+			next := map[string]int{}
+			for _, payload := range sink.sentPayloads() {
+				key, seq, _ := strings.Cut(payload, "/")
+				if n, _ := strconv.Atoi(seq); n != next[key] {
+					t.Fatalf("sent %s, want %s/%d, the messages of %s are out of order", payload, key, next[key], key)
+				}
+				next[key]++
+			}
+		})
+	}
+}
+
+// payloads returns the payloads of the messages as strings
+func payloads(messages []any) []any {
+	values := make([]any, len(messages))
+	for i, msg := range messages {
+		values[i] = string(msg.(*Message).Payload)
+	}
+	return values
+}
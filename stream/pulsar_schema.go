@@ -0,0 +1,166 @@
+package stream
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/ashutosh-the-beast/newknox/config"
+)
+
+const (
+	// PulsarJSONSchema specifies that the messages of the topic are validated against a JSON schema
+	PulsarJSONSchema = "json"
+
+	// PulsarAvroSchema specifies that the messages of the topic are validated against an Avro schema
+	PulsarAvroSchema = "avro"
+
+	// PulsarProtobufSchema specifies that the messages of the topic are validated against a Protobuf schema
+	PulsarProtobufSchema = "protobuf"
+)
+
+// NewPulsarSchema returns the pulsar schema of the given type. The definition is
+// the Avro schema describing the messages, as required by pulsar for all the types.
+func NewPulsarSchema(schemaType, definition string) (pulsar.Schema, error) {
+	if definition == "" {
+		return nil, fmt.Errorf("schema definition is not configured")
+	}
+
+	switch strings.ToLower(schemaType) {
+	case PulsarJSONSchema:
+		return pulsar.NewJSONSchemaWithValidation(definition, nil)
+	case PulsarAvroSchema:
+		return pulsar.NewAvroSchemaWithValidation(definition, nil)
+	case PulsarProtobufSchema:
+		return pulsar.NewProtoSchemaWithValidation(definition, nil)
+	}
+	return nil, fmt.Errorf("schema type %s not supported", schemaType)
+}
+
+// NewPulsarSchemaFor returns the pulsar schema of the given type (json or avro)
+// with the definition derived from the Go type of v. Fields are named after their
+// json tags, hence the messages should be encoded with the json or avro codecs.
+func NewPulsarSchemaFor(schemaType string, v any) (pulsar.Schema, error) {
+	switch strings.ToLower(schemaType) {
+	case PulsarJSONSchema, PulsarAvroSchema:
+	default:
+		return nil, fmt.Errorf("schema type %s can not be derived from a Go type", schemaType)
+	}
+
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("schema can only be derived from a struct, got %T", v)
+	}
+
+	def, err := avroType(t)
+	if err != nil {
+		return nil, err
+	}
+	definition, err := json.Marshal(def)
+	if err != nil {
+		return nil, err
+	}
+	return NewPulsarSchema(schemaType, string(definition))
+}
+
+// newPulsarSchemaFromConfig returns the pulsar schema configured for a topic, if any
+func newPulsarSchemaFromConfig(opt *config.PulsarSchemaConfig) (pulsar.Schema, error) {
+	if opt == nil {
+		return nil, nil
+	}
+
+	definition := opt.Definition
+	if opt.DefinitionFile != "" {
+		data, err := os.ReadFile(opt.DefinitionFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read schema definition file. %s", err)
+		}
+		definition = string(data)
+	}
+	return NewPulsarSchema(opt.Type, definition)
+}
+
+// avroType returns the Avro schema of the Go type
+func avroType(t reflect.Type) (any, error) {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean", nil
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16:
+		return "int", nil
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return "long", nil
+	case reflect.Float32:
+		return "float", nil
+	case reflect.Float64:
+		return "double", nil
+	case reflect.String:
+		return "string", nil
+	case reflect.Pointer:
+		elem, err := avroType(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return []any{"null", elem}, nil
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return "bytes", nil
+		}
+		items, err := avroType(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"type": "array", "items": items}, nil
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("unsupported map key type %s", t.Key())
+		}
+		values, err := avroType(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"type": "map", "values": values}, nil
+	case reflect.Struct:
+		return avroRecord(t)
+	}
+	return nil, fmt.Errorf("unsupported type %s", t)
+}
+
+// avroRecord returns the Avro record schema of the struct type
+func avroRecord(t reflect.Type) (any, error) {
+	fields := []map[string]any{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name := field.Name
+		if tag, ok := field.Tag.Lookup("json"); ok {
+			tagName, _, _ := strings.Cut(tag, ",")
+			if tagName == "-" {
+				continue
+			}
+			if tagName != "" {
+				name = tagName
+			}
+		}
+
+		fieldType, err := avroType(field.Type)
+		if err != nil {
+			return nil, fmt.Errorf("field %s.%s: %s", t.Name(), field.Name, err)
+		}
+		fields = append(fields, map[string]any{"name": name, "type": fieldType})
+	}
+
+	return map[string]any{
+		"type":   "record",
+		"name":   t.Name(),
+		"fields": fields,
+	}, nil
+}
@@ -4,6 +4,8 @@ package stream
 import (
 	"context"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/apache/pulsar-client-go/pulsar"
 	"github.com/ashutosh-the-beast/newknox/config"
@@ -12,37 +14,80 @@ import (
 
 // PulsarSink implements `stream.Sink` interface for Apache Pulsar
 type PulsarSink struct {
-	client   pulsar.Client
-	options  pulsar.ClientOptions
-	producer pulsar.Producer
-	topic    string
-	pubName  string
+	client      pulsar.Client
+	options     pulsar.ClientOptions
+	producerOpt config.PulsarProducerConfig
+	producer    pulsar.Producer
+	topic       string
+	pubName     string
+	schema      pulsar.Schema
+	schemaOpt   *config.PulsarSchemaConfig
+	telemetry   *telemetry
 }
 
-// NewPulsarSink returns a stream sink for Apache Pulsar
+// NewPulsarSink returns a stream sink for Apache Pulsar.
+// The producer uses the schema configured in `pulsar.topics.<topic>.schema`, if any.
 func NewPulsarSink(topic, publisher string) *PulsarSink {
+	return NewPulsarSinkWithConfig(config.Current().Pulsar, topic, publisher)
+}
+
+// NewPulsarSinkWithConfig returns a stream sink for Apache Pulsar with the given configuration
+func NewPulsarSinkWithConfig(cfg config.PulsarConfig, topic, publisher string) *PulsarSink {
 	return &PulsarSink{
-		options: config.Pulsar.Options,
-		topic:   config.Pulsar.TopicPrefix + topic,
-		pubName: publisher,
+		options:     cfg.Options,
+		producerOpt: cfg.Producer,
+		topic:       cfg.TopicPrefix + topic,
+		pubName:     publisher,
+		schemaOpt:   cfg.Topics[strings.ToLower(topic)].Schema,
 	}
 }
 
+// NewPulsarSinkWithSchema returns a stream sink for Apache Pulsar with a producer using
+// the given schema (see NewPulsarSchema and NewPulsarSchemaFor). The payload of the
+// messages should be encoded accordingly, i.e. with the json or avro codec.
+func NewPulsarSinkWithSchema(topic, publisher string, schema pulsar.Schema) *PulsarSink {
+	return NewPulsarSinkWithSchemaConfig(config.Current().Pulsar, topic, publisher, schema)
+}
+
+// NewPulsarSinkWithSchemaConfig returns a stream sink for Apache Pulsar with the given
+// configuration, and a producer using the given schema instead of the configured schema
+func NewPulsarSinkWithSchemaConfig(cfg config.PulsarConfig, topic, publisher string, schema pulsar.Schema) *PulsarSink {
+	ps := NewPulsarSinkWithConfig(cfg, topic, publisher)
+	ps.schema = schema
+	return ps
+}
+
 // Connect implements `Sink.Connect()`
 func (ps *PulsarSink) Connect() (err error) {
+	if ps.schema == nil {
+		ps.schema, err = newPulsarSchemaFromConfig(ps.schemaOpt)
+		if err != nil {
+			return fmt.Errorf("PulsarSink: Invalid schema for topic %s. %s", ps.topic, err)
+		}
+	}
+
 	ps.client, err = pulsar.NewClient(ps.options)
 	if err != nil {
 		return fmt.Errorf("PulsarSink: Failed to create pulsar client. %s", err)
 	}
 
 	ps.producer, err = ps.client.CreateProducer(pulsar.ProducerOptions{
-		Topic:              ps.topic,
-		Name:               ps.pubName,
-		MaxPendingMessages: 1,
-		BatchingMaxSize:    5242880, // 5MB
+		Topic:                   ps.topic,
+		Name:                    ps.pubName,
+		SendTimeout:             ps.producerOpt.SendTimeout,
+		MaxPendingMessages:      ps.producerOpt.MaxPendingMessages,
+		DisableBlockIfQueueFull: ps.producerOpt.DisableBlockIfQueueFull,
+		DisableBatching:         ps.producerOpt.DisableBatching,
+		BatchingMaxPublishDelay: ps.producerOpt.BatchingMaxPublishDelay,
+		BatchingMaxMessages:     ps.producerOpt.BatchingMaxMessages,
+		BatchingMaxSize:         ps.producerOpt.BatchingMaxSize,
+		Schema:                  ps.schema,
 	})
 	if err != nil {
 		ps.client.Close()
+		if strings.Contains(err.Error(), "IncompatibleSchema") {
+			return fmt.Errorf("PulsarSink: Schema is incompatible with the schema of topic %s. %s", ps.topic, err)
+		}
 		return fmt.Errorf("PulsarSink: Failed to create a producer for topic %s. %s", ps.topic, err)
 	}
 
@@ -51,58 +96,62 @@ func (ps *PulsarSink) Connect() (err error) {
 
 // Flush implements `sink.Flush()`
 func (ps *PulsarSink) Flush(data []byte) error {
-	_, err := ps.producer.Send(context.Background(), &pulsar.ProducerMessage{Payload: data})
+	return ps.FlushMessage(&Message{Payload: data})
+}
+
+// FlushMessage implements `sink.FlushMessage()`
+func (ps *PulsarSink) FlushMessage(msg *Message) error {
+	msg, span := ps.telemetry.startProducerSpan(config.PulsarDriver, ps.topic, msg)
+	start := time.Now()
+	_, err := ps.producer.Send(context.Background(), newPulsarMessage(msg))
+	ps.telemetry.getMetrics().ObserveFlush(config.PulsarDriver, ps.topic, len(msg.Payload), time.Since(start), err)
+	endSpan(span, err)
 	if err != nil {
 		return fmt.Errorf(
 			"PulsarSink: Failed to send message. Topic - %s, Message - %s, Error - %s",
-			ps.topic, string(data), err)
+			ps.topic, string(msg.Payload), err)
 	}
 
-	var msg string
-	if len(data) > 100 {
-		msg = string(data[:100]) + "..."
-	} else {
-		msg = string(data)
-	}
-	log.Info().Msgf("PulsarSink: Topic - %s | Message - %s", ps.topic, msg)
+	log.Info().Msgf("PulsarSink: Topic - %s | Message - %s", ps.topic, truncatePayload(msg.Payload))
 	return nil
 }
 
-// ProcessChannel implements `Sink.ProcessChannel()`
-func (ps *PulsarSink) ProcessChannel(ctx context.Context, events chan any, processFn SinkProcessFunc) {
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case msg, ok := <-events:
-			if !ok {
-				log.Info().Msgf("PulsarSink: Processed all the messages from sink channel")
-				return
-			}
-
-			var bytes []byte
-			var err error
-			if processFn != nil {
-				bytes, err = processFn(msg)
-				if err != nil {
-					log.Error().Msgf("PulsarSink: Failed to process message from sink channel. msg=%s, err=%s", msg, err)
-					continue
-				}
-			} else {
-				bytes, ok = msg.([]byte)
-				if !ok {
-					log.Error().Msgf("PulsarSink: Invalid data type sent through sink channel.")
-					continue
-				}
-			}
+// FlushAsync implements `AsyncSink.FlushAsync()`
+func (ps *PulsarSink) FlushAsync(data []byte, callback func(error)) {
+	ps.FlushMessageAsync(&Message{Payload: data}, callback)
+}
 
-			err = ps.Flush(bytes)
+// FlushMessageAsync implements `AsyncSink.FlushMessageAsync()`
+func (ps *PulsarSink) FlushMessageAsync(msg *Message, callback func(error)) {
+	msg, span := ps.telemetry.startProducerSpan(config.PulsarDriver, ps.topic, msg)
+	start := time.Now()
+	ps.producer.SendAsync(context.Background(), newPulsarMessage(msg),
+		func(_ pulsar.MessageID, _ *pulsar.ProducerMessage, err error) {
+			ps.telemetry.getMetrics().ObserveFlush(config.PulsarDriver, ps.topic, len(msg.Payload), time.Since(start), err)
+			endSpan(span, err)
 			if err != nil {
-				log.Error().Msg(err.Error())
-				continue
+				err = fmt.Errorf(
+					"PulsarSink: Failed to send message. Topic - %s, Message - %s, Error - %s",
+					ps.topic, string(msg.Payload), err)
 			}
-		}
+			if callback != nil {
+				callback(err)
+			}
+		})
+}
+
+// Wait implements `AsyncSink.Wait()`
+func (ps *PulsarSink) Wait() error {
+	err := ps.producer.Flush()
+	if err != nil {
+		return fmt.Errorf("PulsarSink: Failed to flush pending messages. Topic - %s, Error - %s", ps.topic, err)
 	}
+	return nil
+}
+
+// ProcessChannel implements `Sink.ProcessChannel()`
+func (ps *PulsarSink) ProcessChannel(ctx context.Context, events chan any, processFn SinkProcessFunc, opts ...ProcessOption) ProcessSummary {
+	return processChannel(ctx, ps, events, processFn.messageFunc(), opts...)
 }
 
 // Disconnect implements `Sink.Disconnect()`
@@ -110,3 +159,20 @@ func (ps *PulsarSink) Disconnect() {
 	ps.producer.Close()
 	ps.client.Close()
 }
+
+func (ps *PulsarSink) metricLabels() (string, string) {
+	return config.PulsarDriver, ps.topic
+}
+
+func (ps *PulsarSink) sinkTelemetry() *telemetry {
+	return ps.telemetry
+}
+
+func newPulsarMessage(msg *Message) *pulsar.ProducerMessage {
+	return &pulsar.ProducerMessage{
+		Payload:    msg.Payload,
+		Key:        msg.Key,
+		Properties: msg.Properties,
+		EventTime:  msg.EventTime,
+	}
+}
@@ -0,0 +1,36 @@
+package stream
+
+import (
+	"testing"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/ashutosh-the-beast/newknox/config"
+)
+
+func TestNewPulsarSinkWithSchemaConfig(t *testing.T) {
+	type book struct {
+		Book   string `json:"book"`
+		Author string `json:"author"`
+	}
+	schema, err := NewPulsarSchemaFor(PulsarJSONSchema, book{})
+	if err != nil {
+		t.Fatalf("NewPulsarSchemaFor() = %v", err)
+	}
+
+	cfg := config.PulsarConfig{
+		TopicPrefix: "persistent://public/kmux/",
+		Options:     pulsar.ClientOptions{URL: "pulsar://pulsar:6650"},
+		Topics: map[string]config.PulsarTopicConfig{
+			"book": {Schema: &config.PulsarSchemaConfig{Type: PulsarAvroSchema, Definition: "{}"}},
+		},
+	}
+	ps := NewPulsarSinkWithSchemaConfig(cfg, "book", "kmux-pub", schema)
+
+	if ps.topic != "persistent://public/kmux/book" || ps.pubName != "kmux-pub" || ps.options.URL != cfg.Options.URL {
+		t.Errorf("sink topic = %s, publisher = %s, url = %s, want the given configuration", ps.topic, ps.pubName, ps.options.URL)
+	}
+	// the given schema is used instead of the schema configured for the topic
+	if ps.schema != schema {
+		t.Errorf("sink schema = %v, want the given schema", ps.schema)
+	}
+}
@@ -0,0 +1,160 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/ashutosh-the-beast/newknox/config"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// receiveRetryInitialInterval and receiveRetryMaxInterval bound the delays between the receive retries
+	receiveRetryInitialInterval = 100 * time.Millisecond
+	receiveRetryMaxInterval     = 10 * time.Second
+)
+
+// PulsarSource implements `stream.Source` interface for Apache Pulsar
+type PulsarSource struct {
+	client   pulsar.Client
+	options  pulsar.ClientOptions
+	consumer pulsar.Consumer
+	topic    string
+	subName  string
+	subType  pulsar.SubscriptionType
+
+	telemetry *telemetry
+}
+
+// NewPulsarSource returns a stream source for Apache Pulsar
+func NewPulsarSource(topic, subscription string) *PulsarSource {
+	return NewPulsarSourceWithConfig(config.Current().Pulsar, topic, subscription)
+}
+
+// NewPulsarSourceWithConfig returns a stream source for Apache Pulsar with the given configuration
+func NewPulsarSourceWithConfig(cfg config.PulsarConfig, topic, subscription string) *PulsarSource {
+	return &PulsarSource{
+		options: cfg.Options,
+		topic:   cfg.TopicPrefix + topic,
+		subName: subscription,
+		subType: cfg.SubscriptionType,
+	}
+}
+
+// Connect implements `Source.Connect()`
+func (ps *PulsarSource) Connect() (err error) {
+	if ps.subName == "" {
+		return fmt.Errorf("PulsarSource: Subscription name is not configured for topic %s", ps.topic)
+	}
+
+	ps.client, err = pulsar.NewClient(ps.options)
+	if err != nil {
+		return fmt.Errorf("PulsarSource: Failed to create pulsar client. %s", err)
+	}
+
+	ps.consumer, err = ps.client.Subscribe(pulsar.ConsumerOptions{
+		Topic:            ps.topic,
+		SubscriptionName: ps.subName,
+		Type:             ps.subType,
+	})
+	if err != nil {
+		ps.client.Close()
+		return fmt.Errorf("PulsarSource: Failed to subscribe to topic %s. %s", ps.topic, err)
+	}
+
+	return nil
+}
+
+// Receive implements `Source.Receive()`
+func (ps *PulsarSource) Receive(ctx context.Context) (*SourceMessage, error) {
+	msg, err := ps.consumer.Receive(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			ps.telemetry.getMetrics().ObserveReceive(config.PulsarDriver, ps.topic, 0, err)
+		}
+		return nil, fmt.Errorf("PulsarSource: Failed to receive message. Topic - %s, Error - %w", ps.topic, err)
+	}
+
+	ps.telemetry.getMetrics().ObserveReceive(config.PulsarDriver, ps.topic, len(msg.Payload()), nil)
+
+	return &SourceMessage{
+		Message: Message{
+			Key:        msg.Key(),
+			Properties: msg.Properties(),
+			EventTime:  msg.EventTime(),
+			Payload:    msg.Payload(),
+		},
+		Topic:     msg.Topic(),
+		raw:       msg,
+		telemetry: ps.telemetry,
+	}, nil
+}
+
+// Ack implements `Source.Ack()`
+func (ps *PulsarSource) Ack(msg *SourceMessage) error {
+	pmsg, ok := msg.raw.(pulsar.Message)
+	if !ok {
+		return fmt.Errorf("PulsarSource: Failed to ack message. Message was not received from pulsar")
+	}
+	if err := ps.consumer.Ack(pmsg); err != nil {
+		return fmt.Errorf("PulsarSource: Failed to ack message. Topic - %s, Error - %s", ps.topic, err)
+	}
+	return nil
+}
+
+// Nack implements `Source.Nack()`
+func (ps *PulsarSource) Nack(msg *SourceMessage) error {
+	pmsg, ok := msg.raw.(pulsar.Message)
+	if !ok {
+		return fmt.Errorf("PulsarSource: Failed to nack message. Message was not received from pulsar")
+	}
+	ps.consumer.Nack(pmsg)
+	return nil
+}
+
+// Subscribe implements `Source.Subscribe()`. Receive failures are retried with
+// exponential backoff, and Subscribe returns once the consumer is closed.
+func (ps *PulsarSource) Subscribe(ctx context.Context, handleFn SourceHandleFunc) {
+	b := newBackoff(receiveRetryInitialInterval, receiveRetryMaxInterval, 2, 0.2)
+	for {
+		msg, err := ps.Receive(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			var perr *pulsar.Error
+			if errors.As(err, &perr) && perr.Result() == pulsar.ConsumerClosed {
+				log.Error().Msgf("PulsarSource: Consumer for topic %s is closed. Stopped receiving messages", ps.topic)
+				return
+			}
+			log.Error().Msg(err.Error())
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(b.next()):
+			}
+			continue
+		}
+		b.reset()
+
+		if err = handleFn(msg); err != nil {
+			log.Error().Msgf("PulsarSource: Failed to handle message. Topic - %s, Error - %s", ps.topic, err)
+			err = ps.Nack(msg)
+		} else {
+			err = ps.Ack(msg)
+		}
+		if err != nil {
+			log.Error().Msg(err.Error())
+		}
+	}
+}
+
+// Disconnect implements `Source.Disconnect()`
+func (ps *PulsarSource) Disconnect() {
+	ps.consumer.Close()
+	ps.client.Close()
+}
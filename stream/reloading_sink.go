@@ -0,0 +1,131 @@
+package stream
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ashutosh-the-beast/newknox/config"
+	"github.com/rs/zerolog/log"
+)
+
+// ReloadingSink implements `stream.Sink` interface over the sink driver configured in kmux
+// configuration. Whenever the configuration section of the driver (or `kmux`) changes,
+// a new sink is created and connected, and the previous sink is disconnected,
+// i.e. when a new knox-gateway server is configured.
+//
+// The configuration is reloaded only when config.Options.WatchConfig is set.
+type ReloadingSink struct {
+	mu          sync.RWMutex
+	sink        Sink
+	topic       string
+	connected   bool
+	unsubscribe func()
+}
+
+// NewReloadingSink returns a stream sink based on kmux configuration which reconnects
+// whenever the configuration of the sink driver changes
+func NewReloadingSink(topic string) (*ReloadingSink, error) {
+	sink, err := NewSink(topic)
+	if err != nil {
+		return nil, err
+	}
+
+	rs := &ReloadingSink{
+		sink:  sink,
+		topic: topic,
+	}
+	rs.unsubscribe = config.Subscribe(rs.onConfigChange)
+	return rs, nil
+}
+
+// Connect implements `Sink.Connect()`
+func (rs *ReloadingSink) Connect() error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	if err := rs.sink.Connect(); err != nil {
+		return err
+	}
+	rs.connected = true
+	return nil
+}
+
+// Flush implements `Sink.Flush()`
+func (rs *ReloadingSink) Flush(data []byte) error {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	return rs.sink.Flush(data)
+}
+
+// FlushMessage implements `Sink.FlushMessage()`
+func (rs *ReloadingSink) FlushMessage(msg *Message) error {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	return rs.sink.FlushMessage(msg)
+}
+
+// ProcessChannel implements `Sink.ProcessChannel()`
+func (rs *ReloadingSink) ProcessChannel(ctx context.Context, events chan any, processFn SinkProcessFunc, opts ...ProcessOption) ProcessSummary {
+	return processChannel(ctx, rs, events, processFn.messageFunc(), opts...)
+}
+
+// Disconnect implements `Sink.Disconnect()`. The sink stops following configuration changes.
+func (rs *ReloadingSink) Disconnect() {
+	rs.unsubscribe()
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	rs.sink.Disconnect()
+	rs.connected = false
+}
+
+func (rs *ReloadingSink) metricLabels() (string, string) {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	return metricLabels(rs.sink)
+}
+
+func (rs *ReloadingSink) sinkTelemetry() *telemetry {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	return sinkTelemetry(rs.sink)
+}
+
+func (rs *ReloadingSink) onConfigChange(event config.ChangeEvent) {
+	if !event.Changed("kmux") && !event.Changed(config.Current().App.Sink.StreamDriver) {
+		return
+	}
+
+	rs.mu.RLock()
+	connected := rs.connected
+	rs.mu.RUnlock()
+	if !connected {
+		return
+	}
+
+	sink, err := NewSink(rs.topic)
+	if err != nil {
+		log.Error().Msgf("ReloadingSink: Failed to create sink for topic %s with new configuration. %s", rs.topic, err)
+		return
+	}
+
+	// connect before swapping, so that the previous sink keeps flushing meanwhile
+	if err = sink.Connect(); err != nil {
+		log.Error().Msgf("ReloadingSink: Failed to connect sink for topic %s with new configuration. %s", rs.topic, err)
+		return
+	}
+
+	rs.mu.Lock()
+	previous := rs.sink
+	if !rs.connected {
+		rs.mu.Unlock()
+		sink.Disconnect()
+		return
+	}
+	rs.sink = sink
+	rs.mu.Unlock()
+
+	previous.Disconnect()
+	log.Info().Msgf("ReloadingSink: Reconnected sink for topic %s with new configuration", rs.topic)
+}
@@ -2,10 +2,8 @@ package stream
 
 import (
 	"context"
-	"fmt"
 
 	"github.com/ashutosh-the-beast/newknox/config"
-	"github.com/rs/xid"
 )
 
 // SinkProcessFunc describes the prototype for functions that can be passed to Sink.ProcessChannel()
@@ -20,28 +18,56 @@ type Sink interface {
 	// blocks until the data is sent to the sink endpoint.
 	Flush([]byte) error
 
+	// FlushMessage sends a message along with its metadata through the sink.
+	// The function call blocks until the message is sent to the sink endpoint.
+	// Metadata not supported by the sink endpoint is ignored.
+	FlushMessage(*Message) error
+
 	// Disconnect terminates the connection with the sink
 	Disconnect()
 
 	// ProcessChannel actively fetch messages from the channel and calls
 	// SinkProcessFunc on each message. The data returned from SinkProcessFunc
-	// will be flushed through the sink.
+	// will be flushed through the sink. If SinkProcessFunc is nil, the channel
+	// is expected to carry []byte, Message or *Message values. Use ProcessMessages()
+	// to set the message metadata from a function.
 	//
 	// By default, this is a blocking function which only returns when the channel
 	// is closed or an error occurred. This function can be prefixed with `go` and
 	// executed as a separate goroutine for asynchronous processing of messages.
 	// In such cases, cancelling the context will stop the processing, free its resource
-	// and exit from the goroutine.
-	ProcessChannel(context.Context, chan any, SinkProcessFunc)
+	// and exit from the goroutine. The returned ProcessSummary reports how many
+	// messages were sent, failed and abandoned.
+	//
+	// ProcessOptions can be passed to get notified of the dropped messages,
+	// retry the failed flushes, send them to a dead letter destination and
+	// drain the queued messages on context cancellation.
+	ProcessChannel(context.Context, chan any, SinkProcessFunc, ...ProcessOption) ProcessSummary
+}
+
+// AsyncSink interface describes the prototypes for sinks supporting non-blocking publishing
+type AsyncSink interface {
+	Sink
+
+	// FlushAsync queues []byte to be sent through the sink and returns without
+	// waiting for the sink endpoint. The callback is invoked with the result
+	// once the data is sent or failed to send. Depending on the sink configuration,
+	// the call may block when the queue of pending messages is full.
+	FlushAsync([]byte, func(error))
+
+	// FlushMessageAsync is similar to FlushAsync, but sends a message along with its metadata
+	FlushMessageAsync(*Message, func(error))
+
+	// Wait blocks until all the queued messages are sent to the sink endpoint
+	Wait() error
 }
 
 // NewSink returns a stream sink driver based on kmux configuration
 func NewSink(topic string) (Sink, error) {
-	if config.App.Sink.StreamDriver == config.PulsarDriver {
-		publisher := fmt.Sprintf("kmux-pub-%s", xid.New().String())
-		return NewPulsarSink(topic, publisher), nil
-	} else if config.App.Sink.StreamDriver == config.KnoxGatewayDriver {
-		return NewKnoxGatewaySink(topic), nil
-	}
-	return nil, fmt.Errorf("sink driver %s not supported", config.App.Sink.StreamDriver)
+	return NewSinkWithConfig(config.Current(), topic)
+}
+
+// NewSinkWithConfig returns a stream sink driver based on the given kmux configuration
+func NewSinkWithConfig(cfg *config.Config, topic string) (Sink, error) {
+	return newSinkFromDriver(cfg, cfg.App.Sink.StreamDriver, topic)
 }
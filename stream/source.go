@@ -0,0 +1,69 @@
+package stream
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ashutosh-the-beast/newknox/config"
+)
+
+// SourceMessage is a single message received through a stream source
+type SourceMessage struct {
+	Message
+	Topic string
+
+	// raw holds the driver specific message handle used for Ack/Nack
+	raw any
+
+	// telemetry is the telemetry of the source which received the message
+	telemetry *telemetry
+}
+
+// SourceHandleFunc describes the prototype for functions that can be passed to Source.Subscribe().
+// Returning nil acknowledges the message, returning an error negatively acknowledges it
+// so that it gets redelivered.
+type SourceHandleFunc func(*SourceMessage) error
+
+// Source interface describes the prototypes for kmux's source APIs for streams
+type Source interface {
+	// Connect establishes connection with the source
+	Connect() error
+
+	// Receive fetches a single message from the source. The function call
+	// blocks until a message is available or the context is cancelled.
+	// The caller is responsible for calling Ack or Nack on the returned message.
+	Receive(context.Context) (*SourceMessage, error)
+
+	// Ack acknowledges the successful processing of a message
+	Ack(*SourceMessage) error
+
+	// Nack acknowledges the failure to process a message so that it gets redelivered
+	Nack(*SourceMessage) error
+
+	// Disconnect terminates the connection with the source
+	Disconnect()
+
+	// Subscribe actively fetch messages from the source and calls SourceHandleFunc
+	// on each message. Messages are acknowledged when SourceHandleFunc returns nil
+	// and negatively acknowledged otherwise.
+	//
+	// This is a blocking function which only returns when the context is cancelled.
+	// This function can be prefixed with `go` and executed as a separate goroutine
+	// for asynchronous processing of messages.
+	Subscribe(context.Context, SourceHandleFunc)
+}
+
+// NewSource returns a stream source driver based on kmux configuration
+func NewSource(topic string) (Source, error) {
+	return NewSourceWithConfig(config.Current(), topic)
+}
+
+// NewSourceWithConfig returns a stream source driver based on the given kmux configuration
+func NewSourceWithConfig(cfg *config.Config, topic string) (Source, error) {
+	if cfg.App.Source.StreamDriver == config.PulsarDriver {
+		ps := NewPulsarSourceWithConfig(cfg.Pulsar, topic, cfg.Pulsar.Subscription)
+		ps.telemetry = telemetryFor(cfg)
+		return ps, nil
+	}
+	return nil, fmt.Errorf("source driver %s not supported", cfg.App.Source.StreamDriver)
+}
@@ -0,0 +1,157 @@
+package stream
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/ashutosh-the-beast/newknox/config"
+	"github.com/ashutosh-the-beast/newknox/metrics"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/ashutosh-the-beast/newknox/stream"
+
+// tracing holds the tracer and the propagator of the trace context
+type tracing struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+// defaultTracing is set by EnableTracing(). Tracing is disabled while it is nil.
+var defaultTracing atomic.Pointer[tracing]
+
+// newTracing returns the tracing using the tracer provider and the propagator. The global
+// tracer provider and the W3C trace context propagator are used when nil.
+func newTracing(tp trace.TracerProvider, p propagation.TextMapPropagator) *tracing {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	if p == nil {
+		p = propagation.TraceContext{}
+	}
+	return &tracing{tracer: tp.Tracer(tracerName), propagator: p}
+}
+
+// EnableTracing enables OpenTelemetry tracing of the stream sinks and sources.
+// Each flush creates a producer span and the span context is propagated through the
+// message properties using the given propagator.
+//
+// The global tracer provider and the W3C trace context propagator are used when nil.
+// Sinks and sources created with a configuration having its own TracerProvider are
+// traced with it instead.
+func EnableTracing(tp trace.TracerProvider, p propagation.TextMapPropagator) {
+	defaultTracing.Store(newTracing(tp, p))
+}
+
+// telemetry holds the metrics and the tracing of a sink or a source.
+// The defaults are used for the ones not set.
+type telemetry struct {
+	metrics *metrics.Metrics
+	tracing *tracing
+}
+
+// telemetryFor returns the telemetry configured in the kmux configuration
+func telemetryFor(cfg *config.Config) *telemetry {
+	t := &telemetry{metrics: cfg.Metrics}
+	if cfg.TracerProvider != nil {
+		t.tracing = newTracing(cfg.TracerProvider, cfg.Propagator)
+	}
+	return t
+}
+
+// getMetrics returns the metrics of the telemetry, or the default metrics
+func (t *telemetry) getMetrics() *metrics.Metrics {
+	if t != nil && t.metrics != nil {
+		return t.metrics
+	}
+	return metrics.Default()
+}
+
+// getTracing returns the tracing of the telemetry, or the default tracing. It returns nil if tracing is disabled.
+func (t *telemetry) getTracing() *tracing {
+	if t != nil && t.tracing != nil {
+		return t.tracing
+	}
+	return defaultTracing.Load()
+}
+
+// WithContext returns a copy of the message carrying the trace context of ctx.
+// The producer span created while flushing the message becomes a child of the span in ctx.
+func (m *Message) WithContext(ctx context.Context) *Message {
+	msg := *m
+	msg.ctx = ctx
+	return &msg
+}
+
+// StartConsumerSpan starts a consumer span for a message received from a source.
+// The span is a child of the span in ctx, and is linked to the producer span propagated
+// through the message properties. The caller is responsible for ending the returned span.
+func StartConsumerSpan(ctx context.Context, msg *SourceMessage) (context.Context, trace.Span) {
+	tr := msg.telemetry.getTracing()
+	if tr == nil {
+		return ctx, trace.SpanFromContext(ctx)
+	}
+
+	opts := []trace.SpanStartOption{
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.destination", msg.Topic),
+			attribute.String("messaging.operation", "receive"),
+		),
+	}
+	producer := trace.SpanContextFromContext(
+		tr.propagator.Extract(context.Background(), propagation.MapCarrier(msg.Properties)))
+	if producer.IsValid() {
+		opts = append(opts, trace.WithLinks(trace.Link{SpanContext: producer}))
+	}
+	return tr.tracer.Start(ctx, msg.Topic+" receive", opts...)
+}
+
+// startProducerSpan starts a producer span for the message being flushed. The parent span
+// is the span of the message context, or the span extracted from the message properties.
+// The returned message is a copy of msg carrying the producer span context in its properties.
+func (t *telemetry) startProducerSpan(driver, topic string, msg *Message) (*Message, trace.Span) {
+	tr := t.getTracing()
+	if tr == nil {
+		return msg, trace.SpanFromContext(context.Background())
+	}
+
+	ctx := msg.ctx
+	if ctx == nil {
+		ctx = tr.propagator.Extract(context.Background(), propagation.MapCarrier(msg.Properties))
+	}
+	ctx, span := tr.tracer.Start(ctx, topic+" send",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", driver),
+			attribute.String("messaging.destination", topic),
+			attribute.Int("messaging.message_payload_size_bytes", len(msg.Payload)),
+		))
+
+	traced := *msg
+	traced.Properties = injectTraceContext(ctx, tr.propagator, msg.Properties)
+	return &traced, span
+}
+
+// endSpan records the outcome of the operation and ends the span
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// injectTraceContext returns a copy of the properties with the trace context of ctx injected
+func injectTraceContext(ctx context.Context, p propagation.TextMapPropagator, properties map[string]string) map[string]string {
+	props := make(map[string]string, len(properties)+1)
+	for key, value := range properties {
+		props[key] = value
+	}
+	p.Inject(ctx, propagation.MapCarrier(props))
+	return props
+}
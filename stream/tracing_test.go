@@ -0,0 +1,159 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/ashutosh-the-beast/newknox/config"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func newTestTelemetry() (*telemetry, *sdktrace.TracerProvider, *tracetest.InMemoryExporter) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	return &telemetry{tracing: newTracing(tp, nil)}, tp, exporter
+}
+
+func findSpan(t *testing.T, spans tracetest.SpanStubs, name string) tracetest.SpanStub {
+	t.Helper()
+	for _, span := range spans {
+		if span.Name == name {
+			return span
+		}
+	}
+	t.Fatalf("span %q not exported, got %d spans", name, len(spans))
+	return tracetest.SpanStub{}
+}
+
+func hasAttribute(span tracetest.SpanStub, attr attribute.KeyValue) bool {
+	for _, kv := range span.Attributes {
+		if kv == attr {
+			return true
+		}
+	}
+	return false
+}
+
+func TestProducerSpan(t *testing.T) {
+	tel, tp, exporter := newTestTelemetry()
+
+	var headers map[string]string
+	producer := mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		headers = map[string]string{}
+		for _, h := range msg.Headers {
+			headers[string(h.Key)] = string(h.Value)
+		}
+		return nil
+	})
+	producer.ExpectSendMessageAndFail(sarama.ErrMessageSizeTooLarge)
+
+	ks := NewKafkaSinkWithConfig(config.KafkaConfig{}, "events", "kmux-test")
+	ks.producer = producer
+	ks.telemetry = tel
+	defer ks.Disconnect()
+
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "parent")
+	msg := &Message{Properties: map[string]string{"source": "kubearmor"}, Payload: []byte("hello")}
+	if err := ks.FlushMessage(msg.WithContext(ctx)); err != nil {
+		t.Fatalf("FlushMessage() = %v", err)
+	}
+	parent.End()
+
+	span := findSpan(t, exporter.GetSpans(), "events send")
+	if span.SpanKind != trace.SpanKindProducer {
+		t.Errorf("span kind = %v, want producer", span.SpanKind)
+	}
+	if span.Parent.SpanID() != parent.SpanContext().SpanID() {
+		t.Errorf("producer span parent = %s, want %s", span.Parent.SpanID(), parent.SpanContext().SpanID())
+	}
+	if !hasAttribute(span, attribute.String("messaging.system", config.KafkaDriver)) ||
+		!hasAttribute(span, attribute.String("messaging.destination", "events")) {
+		t.Errorf("producer span attributes = %v", span.Attributes)
+	}
+
+	want := fmt.Sprintf("00-%s-%s-01", span.SpanContext.TraceID(), span.SpanContext.SpanID())
+	if got := headers["traceparent"]; got != want {
+		t.Errorf("traceparent header = %q, want %q", got, want)
+	}
+	if headers["source"] != "kubearmor" {
+		t.Errorf("headers = %v, want the message properties", headers)
+	}
+	if _, ok := msg.Properties["traceparent"]; ok {
+		t.Error("traceparent was injected into the properties of the caller's message")
+	}
+
+	exporter.Reset()
+	if err := ks.Flush([]byte("large")); err == nil {
+		t.Fatal("Flush() did not return the producer error")
+	}
+	span = findSpan(t, exporter.GetSpans(), "events send")
+	if span.Status.Code != codes.Error {
+		t.Errorf("span status = %v, want error", span.Status.Code)
+	}
+	if span.Parent.IsValid() {
+		t.Errorf("producer span without a trace context has parent %s", span.Parent.SpanID())
+	}
+}
+
+func TestStartConsumerSpan(t *testing.T) {
+	tel, tp, exporter := newTestTelemetry()
+
+	msg, producer := tel.startProducerSpan(config.PulsarDriver, "events", &Message{Payload: []byte("hello")})
+	endSpan(producer, nil)
+
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "handler")
+	received := &SourceMessage{Message: *msg, Topic: "events", telemetry: tel}
+	_, span := StartConsumerSpan(ctx, received)
+	span.End()
+	parent.End()
+
+	consumer := findSpan(t, exporter.GetSpans(), "events receive")
+	if consumer.SpanKind != trace.SpanKindConsumer {
+		t.Errorf("span kind = %v, want consumer", consumer.SpanKind)
+	}
+	if consumer.Parent.SpanID() != parent.SpanContext().SpanID() {
+		t.Errorf("consumer span parent = %s, want %s", consumer.Parent.SpanID(), parent.SpanContext().SpanID())
+	}
+	if len(consumer.Links) != 1 {
+		t.Fatalf("consumer span has %d links, want 1", len(consumer.Links))
+	}
+	// the extracted span context is remote, hence only the ids are compared
+	link, want := consumer.Links[0].SpanContext, producer.SpanContext()
+	if link.TraceID() != want.TraceID() || link.SpanID() != want.SpanID() {
+		t.Errorf("consumer span link = %s/%s, want the producer span %s/%s",
+			link.TraceID(), link.SpanID(), want.TraceID(), want.SpanID())
+	}
+
+	// messages without a trace context start a consumer span without links
+	exporter.Reset()
+	_, span = StartConsumerSpan(context.Background(), &SourceMessage{Topic: "events", telemetry: tel})
+	span.End()
+	if consumer := findSpan(t, exporter.GetSpans(), "events receive"); len(consumer.Links) != 0 {
+		t.Errorf("consumer span has %d links, want none", len(consumer.Links))
+	}
+}
+
+func TestTracingDisabled(t *testing.T) {
+	msg := &Message{Payload: []byte("hello")}
+	traced, span := (&telemetry{}).startProducerSpan(config.PulsarDriver, "events", msg)
+	endSpan(span, errors.New("failed"))
+
+	if traced != msg || span.SpanContext().IsValid() {
+		t.Error("producer span started with tracing disabled")
+	}
+
+	ctx := context.Background()
+	got, span := StartConsumerSpan(ctx, &SourceMessage{Message: *msg, Topic: "events"})
+	if got != ctx || span.SpanContext().IsValid() {
+		t.Error("consumer span started with tracing disabled")
+	}
+}
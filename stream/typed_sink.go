@@ -0,0 +1,73 @@
+package stream
+
+import (
+	"context"
+	"fmt"
+)
+
+// TypedSink wraps a stream sink to flush values of type T, encoded with an
+// encode function or a codec
+type TypedSink[T any] struct {
+	sink   Sink
+	encode func(T) ([]byte, error)
+}
+
+// NewTypedSink wraps the sink to flush values of type T encoded with the encode function
+func NewTypedSink[T any](sink Sink, encode func(T) ([]byte, error)) *TypedSink[T] {
+	return &TypedSink[T]{sink: sink, encode: encode}
+}
+
+// NewTypedSinkWithCodec wraps the sink to flush values of type T encoded with the codec
+func NewTypedSinkWithCodec[T any](sink Sink, codec Codec) *TypedSink[T] {
+	return NewTypedSink(sink, func(v T) ([]byte, error) {
+		return codec.Encode(v)
+	})
+}
+
+// Sink returns the underlying untyped sink
+func (ts *TypedSink[T]) Sink() Sink {
+	return ts.sink
+}
+
+// Connect establishes connection with the underlying sink
+func (ts *TypedSink[T]) Connect() error {
+	return ts.sink.Connect()
+}
+
+// Disconnect terminates the connection with the underlying sink
+func (ts *TypedSink[T]) Disconnect() {
+	ts.sink.Disconnect()
+}
+
+// Flush encodes the value and flushes it through the sink
+func (ts *TypedSink[T]) Flush(v T) error {
+	data, err := ts.encode(v)
+	if err != nil {
+		return fmt.Errorf("%s: Failed to encode message. %s", sinkName(ts.sink), err)
+	}
+	return ts.sink.Flush(data)
+}
+
+// FlushMessage encodes the value and flushes it through the sink with the key and properties
+func (ts *TypedSink[T]) FlushMessage(v T, key string, properties map[string]string) error {
+	data, err := ts.encode(v)
+	if err != nil {
+		return fmt.Errorf("%s: Failed to encode message. %s", sinkName(ts.sink), err)
+	}
+	return ts.sink.FlushMessage(&Message{Key: key, Properties: properties, Payload: data})
+}
+
+// ProcessChannel encodes the values received from the channel and flushes them through
+// the sink using `Sink.FlushMessage()`, with the same semantics as `Sink.ProcessChannel()`.
+func (ts *TypedSink[T]) ProcessChannel(ctx context.Context, events <-chan T, opts ...ProcessOption) ProcessSummary {
+	p := newProcessor(ts.sink, func(data any) (*Message, error) {
+		// a nil value of an interface type T is received as a nil any
+		v, _ := data.(T)
+		payload, err := ts.encode(v)
+		if err != nil {
+			return nil, err
+		}
+		return &Message{Payload: payload}, nil
+	}, opts...)
+	return runProcessor(ctx, p, events)
+}
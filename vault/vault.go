@@ -0,0 +1,435 @@
+// Package vault implements the routines required to resolve secrets from HashiCorp Vault
+package vault
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ashutosh-the-beast/newknox/config"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// TokenAuth authenticates with a static vault token
+	TokenAuth = "token"
+
+	// KubernetesAuth authenticates with the pod's service account token
+	KubernetesAuth = "kubernetes"
+
+	// AppRoleAuth authenticates with an AppRole role-id and secret-id
+	AppRoleAuth = "approle"
+)
+
+const (
+	defaultKubernetesJWTPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	requestTimeout           = 10 * time.Second
+	minRenewInterval         = 5 * time.Second
+)
+
+// Secret is a secret read from vault
+type Secret struct {
+	Data          map[string]any
+	LeaseID       string
+	LeaseDuration time.Duration
+	Renewable     bool
+}
+
+// Client is a minimal HashiCorp Vault HTTP API client
+type Client struct {
+	options config.HashiVaultConfig
+	http    *http.Client
+
+	mu    sync.Mutex
+	token string
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// response is the generic vault API response envelope
+type response struct {
+	LeaseID       string         `json:"lease_id"`
+	LeaseDuration int            `json:"lease_duration"`
+	Renewable     bool           `json:"renewable"`
+	Data          map[string]any `json:"data"`
+	Auth          *struct {
+		ClientToken   string `json:"client_token"`
+		LeaseDuration int    `json:"lease_duration"`
+		Renewable     bool   `json:"renewable"`
+	} `json:"auth"`
+	Errors []string `json:"errors"`
+}
+
+// NewClient returns a vault client for the given configuration
+func NewClient(options config.HashiVaultConfig) *Client {
+	return &Client{
+		options: options,
+		http:    &http.Client{Timeout: requestTimeout},
+		stop:    make(chan struct{}),
+	}
+}
+
+// Login authenticates with vault using the configured auth method. Renewable
+// tokens are renewed in the background until Close() is called.
+func (c *Client) Login(ctx context.Context) error {
+	auth := c.options.Auth
+
+	var path string
+	var body map[string]any
+	switch auth.Method {
+	case "", TokenAuth:
+		c.setToken(auth.Token)
+		return c.renewToken(ctx)
+
+	case KubernetesAuth:
+		jwtPath := auth.JWTPath
+		if jwtPath == "" {
+			jwtPath = defaultKubernetesJWTPath
+		}
+		jwt, err := ioutil.ReadFile(jwtPath)
+		if err != nil {
+			return fmt.Errorf("VaultClient: Failed to read service account token. %s", err)
+		}
+		path = fmt.Sprintf("auth/%s/login", mountOrDefault(auth.Mount, KubernetesAuth))
+		body = map[string]any{"role": auth.Role, "jwt": strings.TrimSpace(string(jwt))}
+
+	case AppRoleAuth:
+		path = fmt.Sprintf("auth/%s/login", mountOrDefault(auth.Mount, AppRoleAuth))
+		body = map[string]any{"role_id": auth.RoleID, "secret_id": auth.SecretID}
+
+	default:
+		return fmt.Errorf("VaultClient: Auth method %s not supported", auth.Method)
+	}
+
+	resp, err := c.do(ctx, http.MethodPost, path, body)
+	if err != nil {
+		return fmt.Errorf("VaultClient: Failed to login using %s auth. %s", auth.Method, err)
+	}
+	if resp.Auth == nil || resp.Auth.ClientToken == "" {
+		return fmt.Errorf("VaultClient: Failed to login using %s auth. No client token returned", auth.Method)
+	}
+	c.setToken(resp.Auth.ClientToken)
+	log.Info().Msgf("VaultClient: Logged in to vault using %s auth", auth.Method)
+
+	// the token is renewed until its max TTL, then kmux logs in again
+	var renewFn func() (time.Duration, error)
+	if resp.Auth.Renewable {
+		renewFn = c.renewSelf
+	}
+	c.renew(secondsToDuration(resp.Auth.LeaseDuration), renewFn, c.relogin)
+	return nil
+}
+
+// relogin logs in again once the token can not be renewed any more. It is retried until
+// it succeeds or the client is closed.
+func (c *Client) relogin() {
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+		err := c.Login(ctx)
+		cancel()
+		if err == nil {
+			return
+		}
+		log.Error().Msgf("VaultClient: Failed to login again. %s", err)
+
+		if !c.wait(minRenewInterval) {
+			return
+		}
+	}
+}
+
+// renewToken looks up the static token and renews it in the background if it is renewable
+func (c *Client) renewToken(ctx context.Context) error {
+	resp, err := c.do(ctx, http.MethodGet, "auth/token/lookup-self", nil)
+	if err != nil {
+		return fmt.Errorf("VaultClient: Failed to lookup token. %s", err)
+	}
+
+	renewable, _ := resp.Data["renewable"].(bool)
+	ttl, _ := resp.Data["ttl"].(float64)
+	if renewable {
+		c.renew(secondsToDuration(int(ttl)), c.renewSelf, nil)
+	}
+	return nil
+}
+
+func (c *Client) renewSelf() (time.Duration, error) {
+	resp, err := c.do(context.Background(), http.MethodPost, "auth/token/renew-self", nil)
+	if err != nil {
+		return 0, err
+	}
+	if resp.Auth == nil {
+		return 0, nil
+	}
+	return secondsToDuration(resp.Auth.LeaseDuration), nil
+}
+
+// ReadSecret reads the secret at the given path. For KV v2 engines the path
+// is given without the `data/` segment, i.e. `secret/myapp/db`.
+// Renewable leases are renewed in the background until Close() is called.
+func (c *Client) ReadSecret(ctx context.Context, path string) (*Secret, error) {
+	secret, err := c.readSecret(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	if secret.Renewable && secret.LeaseID != "" {
+		c.renew(secret.LeaseDuration, c.leaseRenewer(secret.LeaseID), nil)
+	}
+	return secret, nil
+}
+
+// WatchSecret reads the secret at the given path like ReadSecret(). When the lease of
+// the secret can not be renewed any more, either because the renewal failed or the
+// lease reached its max TTL, the secret is read again and passed to onChange. Failures
+// to read the secret again are passed to onChange as well, and retried until the
+// secret is read or Close() is called.
+func (c *Client) WatchSecret(ctx context.Context, path string, onChange func(*Secret, error)) (*Secret, error) {
+	secret, err := c.readSecret(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	c.watchLease(path, secret, onChange)
+	return secret, nil
+}
+
+func (c *Client) watchLease(path string, secret *Secret, onChange func(*Secret, error)) {
+	var renewFn func() (time.Duration, error)
+	if secret.Renewable && secret.LeaseID != "" {
+		renewFn = c.leaseRenewer(secret.LeaseID)
+	}
+
+	c.renew(secret.LeaseDuration, renewFn, func() {
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+			newSecret, err := c.readSecret(ctx, path)
+			cancel()
+			if err == nil {
+				log.Info().Msgf("VaultClient: Read secret %s again", path)
+				c.watchLease(path, newSecret, onChange)
+				onChange(newSecret, nil)
+				return
+			}
+
+			log.Error().Msgf("VaultClient: Failed to read secret %s again. %s", path, err)
+			onChange(nil, err)
+
+			if !c.wait(minRenewInterval) {
+				return
+			}
+		}
+	})
+}
+
+func (c *Client) readSecret(ctx context.Context, path string) (*Secret, error) {
+	path = c.secretPath(path)
+
+	resp, err := c.do(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("VaultClient: Failed to read secret %s. %s", path, err)
+	}
+
+	data := resp.Data
+	if c.options.KVVersion != 1 {
+		data, _ = resp.Data["data"].(map[string]any)
+	}
+	if data == nil {
+		return nil, fmt.Errorf("VaultClient: Secret %s has no data", path)
+	}
+
+	return &Secret{
+		Data:          data,
+		LeaseID:       resp.LeaseID,
+		LeaseDuration: secondsToDuration(resp.LeaseDuration),
+		Renewable:     resp.Renewable,
+	}, nil
+}
+
+// secretPath returns the API path of the secret. For KV v2 engines the `data/` segment
+// is inserted after the mount, which is `vault.kv-mount` or the first segment of the path.
+func (c *Client) secretPath(path string) string {
+	path = strings.Trim(path, "/")
+	if c.options.KVVersion == 1 {
+		return path
+	}
+
+	mount := strings.Trim(c.options.KVMount, "/")
+	if mount == "" {
+		mount, path, _ = strings.Cut(path, "/")
+	} else if path == mount || strings.HasPrefix(path, mount+"/") {
+		path = strings.TrimPrefix(strings.TrimPrefix(path, mount), "/")
+	}
+	return mount + "/data/" + path
+}
+
+func (c *Client) leaseRenewer(leaseID string) func() (time.Duration, error) {
+	return func() (time.Duration, error) {
+		resp, err := c.do(context.Background(), http.MethodPut, "sys/leases/renew",
+			map[string]any{"lease_id": leaseID})
+		if err != nil {
+			return 0, err
+		}
+		return secondsToDuration(resp.LeaseDuration), nil
+	}
+}
+
+// Close stops renewing the tokens and leases obtained by the client
+func (c *Client) Close() {
+	select {
+	case <-c.stop:
+	default:
+		close(c.stop)
+	}
+	c.wg.Wait()
+}
+
+// renew calls renewFn whenever two thirds of the lease duration has elapsed. When the
+// lease can not be renewed any more, i.e. renewFn is nil or failed, or the lease reached
+// its max TTL, onExpire is called instead and the renewal stops. Without onExpire, a
+// failed renewal is retried until the lease expires.
+func (c *Client) renew(ttl time.Duration, renewFn func() (time.Duration, error), onExpire func()) {
+	if ttl <= 0 || (renewFn == nil && onExpire == nil) {
+		return
+	}
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		expiry := time.Now().Add(ttl)
+		for {
+			// the last renewal is not retried, hence it is not delayed by minRenewInterval
+			interval := ttl * 2 / 3
+			if renewFn != nil {
+				interval = renewInterval(ttl)
+			}
+			if !c.wait(interval) {
+				return
+			}
+
+			if renewFn == nil {
+				onExpire()
+				return
+			}
+
+			newTTL, err := renewFn()
+			if err != nil {
+				log.Error().Msgf("VaultClient: Failed to renew lease. %s", err)
+				if onExpire != nil {
+					onExpire()
+					return
+				}
+				if time.Now().After(expiry) {
+					log.Error().Msg("VaultClient: Lease expired")
+					return
+				}
+				continue
+			}
+			if newTTL <= 0 {
+				continue
+			}
+
+			// a lease renewed for less than its previous duration is capped by its max TTL
+			if newTTL < ttl && onExpire != nil {
+				renewFn = nil
+			}
+			ttl = newTTL
+			expiry = time.Now().Add(ttl)
+		}
+	}()
+}
+
+// wait waits for the given duration and returns false if the client is closed meanwhile
+func (c *Client) wait(d time.Duration) bool {
+	select {
+	case <-c.stop:
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+func renewInterval(ttl time.Duration) time.Duration {
+	interval := ttl * 2 / 3
+	if interval < minRenewInterval {
+		interval = minRenewInterval
+	}
+	return interval
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body map[string]any) (*response, error) {
+	var reqBody *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reqBody = bytes.NewReader(data)
+	} else {
+		reqBody = bytes.NewReader(nil)
+	}
+
+	url := strings.TrimRight(c.options.Server, "/") + "/v1/" + path
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	if token := c.getToken(); token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if c.options.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", c.options.Namespace)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	resp := &response{}
+	err = json.NewDecoder(res.Body).Decode(resp)
+	if res.StatusCode/100 != 2 {
+		if len(resp.Errors) > 0 {
+			return nil, fmt.Errorf("%s (status %d)", strings.Join(resp.Errors, ", "), res.StatusCode)
+		}
+		return nil, fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid response. %s", err)
+	}
+	return resp, nil
+}
+
+func (c *Client) setToken(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token = token
+}
+
+func (c *Client) getToken() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.token
+}
+
+func mountOrDefault(mount, def string) string {
+	if mount == "" {
+		return def
+	}
+	return strings.Trim(mount, "/")
+}
+
+func secondsToDuration(s int) time.Duration {
+	return time.Duration(s) * time.Second
+}
@@ -0,0 +1,301 @@
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ashutosh-the-beast/newknox/config"
+)
+
+// fakeVault is a minimal vault HTTP API serving the given handlers by path
+type fakeVault struct {
+	t        *testing.T
+	mu       sync.Mutex
+	handlers map[string]func(r *http.Request, body map[string]any) (int, any)
+	requests []*http.Request
+}
+
+func newFakeVault(t *testing.T) (*fakeVault, *httptest.Server) {
+	fv := &fakeVault{t: t, handlers: map[string]func(*http.Request, map[string]any) (int, any){}}
+	srv := httptest.NewServer(fv)
+	t.Cleanup(srv.Close)
+	return fv, srv
+}
+
+func (fv *fakeVault) handle(path string, fn func(r *http.Request, body map[string]any) (int, any)) {
+	fv.mu.Lock()
+	defer fv.mu.Unlock()
+	fv.handlers[path] = fn
+}
+
+func (fv *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fv.mu.Lock()
+	fn := fv.handlers[r.Method+" "+r.URL.Path]
+	fv.requests = append(fv.requests, r)
+	fv.mu.Unlock()
+
+	var body map[string]any
+	if r.Body != nil {
+		_ = json.NewDecoder(r.Body).Decode(&body)
+	}
+
+	status, resp := http.StatusNotFound, any(map[string]any{"errors": []string{"not found"}})
+	if fn != nil {
+		status, resp = fn(r, body)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (fv *fakeVault) count(method, path string) int {
+	fv.mu.Lock()
+	defer fv.mu.Unlock()
+	n := 0
+	for _, r := range fv.requests {
+		if r.Method == method && r.URL.Path == path {
+			n++
+		}
+	}
+	return n
+}
+
+func loginResponse(token string) map[string]any {
+	return map[string]any{"auth": map[string]any{"client_token": token, "lease_duration": 0}}
+}
+
+func newTestClient(t *testing.T, srv *httptest.Server, opt config.HashiVaultConfig) *Client {
+	opt.Server = srv.URL
+	c := NewClient(opt)
+	t.Cleanup(c.Close)
+	return c
+}
+
+func TestLoginToken(t *testing.T) {
+	fv, srv := newFakeVault(t)
+	fv.handle("GET /v1/auth/token/lookup-self", func(r *http.Request, _ map[string]any) (int, any) {
+		if got := r.Header.Get("X-Vault-Token"); got != "s.static" {
+			return http.StatusForbidden, map[string]any{"errors": []string{"permission denied"}}
+		}
+		if got := r.Header.Get("X-Vault-Namespace"); got != "team" {
+			t.Errorf("X-Vault-Namespace = %q, want team", got)
+		}
+		return http.StatusOK, map[string]any{"data": map[string]any{"renewable": false, "ttl": 0}}
+	})
+
+	c := newTestClient(t, srv, config.HashiVaultConfig{
+		Namespace: "team",
+		Auth:      config.HashiVaultAuthConfig{Method: TokenAuth, Token: "s.static"},
+	})
+	if err := c.Login(context.Background()); err != nil {
+		t.Fatalf("Login() = %v", err)
+	}
+	if got := c.getToken(); got != "s.static" {
+		t.Errorf("token = %q, want s.static", got)
+	}
+
+	c = newTestClient(t, srv, config.HashiVaultConfig{
+		Auth: config.HashiVaultAuthConfig{Method: TokenAuth, Token: "s.invalid"},
+	})
+	if err := c.Login(context.Background()); err == nil {
+		t.Error("Login() with an invalid token succeeded")
+	}
+}
+
+func TestLoginKubernetes(t *testing.T) {
+	jwtPath := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(jwtPath, []byte("service-account-jwt\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	fv, srv := newFakeVault(t)
+	fv.handle("POST /v1/auth/k8s/login", func(_ *http.Request, body map[string]any) (int, any) {
+		if body["role"] != "kmux" || body["jwt"] != "service-account-jwt" {
+			t.Errorf("login body = %v", body)
+		}
+		return http.StatusOK, loginResponse("s.kubernetes")
+	})
+
+	c := newTestClient(t, srv, config.HashiVaultConfig{
+		Auth: config.HashiVaultAuthConfig{Method: KubernetesAuth, Mount: "/k8s/", Role: "kmux", JWTPath: jwtPath},
+	})
+	if err := c.Login(context.Background()); err != nil {
+		t.Fatalf("Login() = %v", err)
+	}
+	if got := c.getToken(); got != "s.kubernetes" {
+		t.Errorf("token = %q, want s.kubernetes", got)
+	}
+}
+
+func TestLoginAppRole(t *testing.T) {
+	fv, srv := newFakeVault(t)
+	fv.handle("POST /v1/auth/approle/login", func(_ *http.Request, body map[string]any) (int, any) {
+		if body["role_id"] != "role" || body["secret_id"] != "secret" {
+			return http.StatusBadRequest, map[string]any{"errors": []string{"invalid role or secret ID"}}
+		}
+		return http.StatusOK, loginResponse("s.approle")
+	})
+
+	c := newTestClient(t, srv, config.HashiVaultConfig{
+		Auth: config.HashiVaultAuthConfig{Method: AppRoleAuth, RoleID: "role", SecretID: "secret"},
+	})
+	if err := c.Login(context.Background()); err != nil {
+		t.Fatalf("Login() = %v", err)
+	}
+	if got := c.getToken(); got != "s.approle" {
+		t.Errorf("token = %q, want s.approle", got)
+	}
+
+	c = newTestClient(t, srv, config.HashiVaultConfig{
+		Auth: config.HashiVaultAuthConfig{Method: AppRoleAuth, RoleID: "role", SecretID: "wrong"},
+	})
+	if err := c.Login(context.Background()); err == nil {
+		t.Error("Login() with an invalid secret-id succeeded")
+	}
+}
+
+func TestReadSecret(t *testing.T) {
+	secret := map[string]any{"username": "kmux", "password": "pass"}
+
+	fv, srv := newFakeVault(t)
+	fv.handle("GET /v1/kv/kmux/db", func(_ *http.Request, _ map[string]any) (int, any) {
+		return http.StatusOK, map[string]any{"data": secret}
+	})
+	fv.handle("GET /v1/secret/data/kmux/db", func(_ *http.Request, _ map[string]any) (int, any) {
+		return http.StatusOK, map[string]any{"data": map[string]any{"data": secret, "metadata": map[string]any{}}}
+	})
+	fv.handle("GET /v1/team/kv/data/kmux/db", func(_ *http.Request, _ map[string]any) (int, any) {
+		return http.StatusOK, map[string]any{"data": map[string]any{"data": secret, "metadata": map[string]any{}}}
+	})
+
+	tests := []struct {
+		name    string
+		options config.HashiVaultConfig
+		path    string
+	}{
+		{name: "kv v1", options: config.HashiVaultConfig{KVVersion: 1}, path: "kv/kmux/db"},
+		{name: "kv v2", options: config.HashiVaultConfig{KVVersion: 2}, path: "/secret/kmux/db"},
+		{name: "kv v2 mount", options: config.HashiVaultConfig{KVVersion: 2, KVMount: "team/kv"}, path: "team/kv/kmux/db"},
+		{name: "kv v2 relative to mount", options: config.HashiVaultConfig{KVVersion: 2, KVMount: "/team/kv/"}, path: "kmux/db"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t, srv, tt.options)
+			got, err := c.ReadSecret(context.Background(), tt.path)
+			if err != nil {
+				t.Fatalf("ReadSecret() = %v", err)
+			}
+			if got.Data["username"] != "kmux" || got.Data["password"] != "pass" {
+				t.Errorf("ReadSecret().Data = %v, want %v", got.Data, secret)
+			}
+		})
+	}
+
+	c := newTestClient(t, srv, config.HashiVaultConfig{KVVersion: 2})
+	if _, err := c.ReadSecret(context.Background(), "secret/missing"); err == nil {
+		t.Error("ReadSecret() of a missing secret succeeded")
+	}
+}
+
+func TestWatchSecretRereadsFailedLease(t *testing.T) {
+	var mu sync.Mutex
+	reads := 0
+
+	fv, srv := newFakeVault(t)
+	fv.handle("GET /v1/database/creds/kmux", func(_ *http.Request, _ map[string]any) (int, any) {
+		mu.Lock()
+		defer mu.Unlock()
+		reads++
+		return http.StatusOK, map[string]any{
+			"lease_id":       "database/creds/kmux/" + time.Now().String(),
+			"lease_duration": 1,
+			"renewable":      true,
+			"data":           map[string]any{"username": "user-" + string(rune('0'+reads))},
+		}
+	})
+	fv.handle("PUT /v1/sys/leases/renew", func(_ *http.Request, _ map[string]any) (int, any) {
+		return http.StatusBadRequest, map[string]any{"errors": []string{"lease not found"}}
+	})
+
+	c := newTestClient(t, srv, config.HashiVaultConfig{KVVersion: 1})
+	changes := make(chan *Secret, 10)
+	secret, err := c.WatchSecret(context.Background(), "database/creds/kmux", func(s *Secret, err error) {
+		if err != nil {
+			t.Errorf("WatchSecret() onChange error = %v", err)
+			return
+		}
+		changes <- s
+	})
+	if err != nil {
+		t.Fatalf("WatchSecret() = %v", err)
+	}
+	if got := secret.Data["username"]; got != "user-1" {
+		t.Fatalf("username = %v, want user-1", got)
+	}
+
+	// the lease is renewed after minRenewInterval, and read again when the renewal fails
+	select {
+	case s := <-changes:
+		if got := s.Data["username"]; got != "user-2" {
+			t.Errorf("username = %v, want user-2", got)
+		}
+	case <-time.After(minRenewInterval + 5*time.Second):
+		t.Fatal("secret was not read again after the lease renewal failed")
+	}
+	if fv.count(http.MethodPut, "/v1/sys/leases/renew") == 0 {
+		t.Error("lease was not renewed before reading the secret again")
+	}
+}
+
+func TestWatchSecretRereadsExpiringLease(t *testing.T) {
+	var mu sync.Mutex
+	reads := 0
+
+	fv, srv := newFakeVault(t)
+	fv.handle("GET /v1/database/creds/kmux", func(_ *http.Request, _ map[string]any) (int, any) {
+		mu.Lock()
+		defer mu.Unlock()
+		reads++
+		if reads == 2 {
+			return http.StatusInternalServerError, map[string]any{"errors": []string{"internal error"}}
+		}
+		return http.StatusOK, map[string]any{
+			"lease_id":       "database/creds/kmux/lease",
+			"lease_duration": 1,
+			"data":           map[string]any{"username": "kmux"},
+		}
+	})
+
+	c := newTestClient(t, srv, config.HashiVaultConfig{KVVersion: 1})
+	errs := make(chan error, 10)
+	changes := make(chan *Secret, 10)
+	_, err := c.WatchSecret(context.Background(), "database/creds/kmux", func(s *Secret, err error) {
+		if err != nil {
+			errs <- err
+			return
+		}
+		changes <- s
+	})
+	if err != nil {
+		t.Fatalf("WatchSecret() = %v", err)
+	}
+
+	// the non-renewable lease is read again before it expires, the failure is surfaced and retried
+	select {
+	case <-errs:
+	case <-time.After(5 * time.Second):
+		t.Fatal("failure to read the secret again was not surfaced")
+	}
+	select {
+	case <-changes:
+	case <-time.After(minRenewInterval + 5*time.Second):
+		t.Fatal("secret was not read again after the failure")
+	}
+}